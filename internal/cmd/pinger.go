@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"sync"
 	"syscall"
 
@@ -41,12 +43,17 @@ var (
 	}
 
 	arguments = charmer.Arguments{
-		"config":    {Default: "", Help: "Configuration file"},
-		"debug":     {Default: false, Help: "log debug messages"},
-		"addr":      {Default: ":8080", Help: "Prometheus listener address"},
-		"ipv4":      {Default: true, Help: "ping ipv4 address"},
-		"ipv6":      {Default: true, Help: "ping ipv6 address"},
-		"ignore-id": {Default: false, Help: "ignore ICMP MsgID (use this when running inside a container)"},
+		"config":            {Default: "", Help: "Configuration file"},
+		"debug":             {Default: false, Help: "log debug messages"},
+		"addr":              {Default: ":8080", Help: "Prometheus listener address"},
+		"ipv4":              {Default: true, Help: "ping ipv4 address"},
+		"ipv6":              {Default: true, Help: "ping ipv6 address"},
+		"ignore-id":         {Default: false, Help: "ignore ICMP MsgID (datagram sockets always ignore it)"},
+		"socket":            {Default: "auto", Help: "icmp socket type: raw (requires CAP_NET_RAW), datagram or auto"},
+		"kernel-timestamps": {Default: true, Help: "use kernel receive timestamps to measure latency"},
+		"jitter":            {Default: "0s", Help: "maximum random delay added to each ping"},
+		"max-pps":           {Default: 0, Help: "maximum number of packets sent per second, across all targets (0: no limit)"},
+		"batch-size":        {Default: 1, Help: "maximum number of packets sent or read per system call (Linux only)"},
 	}
 )
 
@@ -78,8 +85,13 @@ func initConfig() {
 func run(ctx context.Context, cmd *cobra.Command, args []string, v *viper.Viper, r prometheus.Registerer, l *slog.Logger) error {
 	targets := configuration.GetTargets(v, args)
 
+	mode, err := ping.ParseSocketMode(v.GetString("socket"))
+	if err != nil {
+		return err
+	}
 	socketOptions := []ping.SocketOption{
 		ping.WithLogger(l.With("component", "socket")),
+		ping.WithMode(mode),
 	}
 	if v.GetBool("ipv4") {
 		socketOptions = append(socketOptions, ping.WithIPv4())
@@ -90,15 +102,20 @@ func run(ctx context.Context, cmd *cobra.Command, args []string, v *viper.Viper,
 	if v.GetBool("ignore-id") {
 		socketOptions = append(socketOptions, ping.WithoutCheckID())
 	}
+	if v.GetBool("kernel-timestamps") {
+		socketOptions = append(socketOptions, ping.WithKernelTimestamps())
+	}
+	if batchSize := v.GetInt("batch-size"); batchSize > 1 {
+		socketOptions = append(socketOptions, ping.WithBatchSize(batchSize))
+	}
 
 	l.Info("pinger started", "targets", targets, "version", cmd.Version)
 
-	s, err := ping.New(socketOptions...)
-	if err != nil {
-		return fmt.Errorf("failed to create icmp socket: %w", err)
-	}
-
-	targetPinger := pinger.New(targets, s, l)
+	targetPinger := pinger.New(targets, socketFactory(socketOptions), l,
+		pinger.WithJitter(v.GetDuration("jitter")),
+		pinger.WithMaxPPS(v.GetInt("max-pps")),
+		pinger.WithBatchSize(v.GetInt("batch-size")),
+	)
 	p := collector.Collector{
 		Targets: targets,
 		Logger:  l,
@@ -109,6 +126,7 @@ func run(ctx context.Context, cmd *cobra.Command, args []string, v *viper.Viper,
 	wg.Go(func() {
 		m := http.NewServeMux()
 		m.Handle("/metrics", promhttp.Handler())
+		m.Handle("/routes", pinger.RoutesHandler(targets))
 		promServer := http.Server{
 			Addr:    v.GetString("addr"),
 			Handler: m,
@@ -125,3 +143,42 @@ func run(ctx context.Context, cmd *cobra.Command, args []string, v *viper.Viper,
 	wg.Wait()
 	return nil
 }
+
+// socketFactory returns a pinger.SocketFactory that creates a ping.Socket with the provided options,
+// plus the options for the SocketConfig.
+func socketFactory(options []ping.SocketOption) pinger.SocketFactory {
+	return func(cfg pinger.SocketConfig) (pinger.Socket, error) {
+		opts := slices.Clone(options)
+		if cfg.Source != "" {
+			ip := net.ParseIP(cfg.Source)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid source address: %q", cfg.Source)
+			}
+			opts = append(opts, ping.WithSourceAddress(ip))
+		}
+		if cfg.Interface != "" {
+			opts = append(opts, ping.WithInterface(cfg.Interface))
+		}
+		if cfg.Mark != 0 {
+			opts = append(opts, ping.WithMark(cfg.Mark))
+		}
+		if cfg.Netns != "" {
+			opts = append(opts, ping.WithNetNS(cfg.Netns))
+		}
+		if cfg.DSCP != "" {
+			dscp, err := ping.ParseDSCP(cfg.DSCP)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, ping.WithTOS(dscp<<2))
+		}
+		if cfg.PMTU {
+			opts = append(opts, ping.WithDontFragment())
+		}
+		s, err := ping.New(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create icmp socket: %w", err)
+		}
+		return pinger.NewSocket(s), nil
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/clambin/pinger/internal/pinger"
+	"github.com/clambin/pinger/ping"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/spf13/viper"
@@ -37,3 +39,12 @@ func TestPinger(t *testing.T) {
 		return err == nil && count > 0
 	}, 10*time.Second, 500*time.Millisecond)
 }
+
+func TestSocketFactory(t *testing.T) {
+	factory := socketFactory([]ping.SocketOption{ping.WithLogger(slog.New(slog.DiscardHandler))})
+
+	_, err := factory(pinger.SocketConfig{Source: "not-an-ip"})
+	assert.Error(t, err)
+	_, err = factory(pinger.SocketConfig{DSCP: "foo"})
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package collector
 
 import (
 	"log/slog"
+	"strconv"
 
 	"github.com/clambin/pinger/internal/pinger"
 	"github.com/prometheus/client_golang/prometheus"
@@ -11,19 +12,139 @@ var (
 	packetsSentMetric = prometheus.NewDesc(
 		prometheus.BuildFQName("pinger", "", "packets_sent_count"),
 		"Total packets sent",
-		[]string{"host"},
+		[]string{"host", "netns", "dscp"},
 		nil,
 	)
 	packetsReceivedMetric = prometheus.NewDesc(
 		prometheus.BuildFQName("pinger", "", "packets_received_count"),
 		"Total packet received",
-		[]string{"host"},
+		[]string{"host", "netns", "dscp"},
 		nil,
 	)
 	latencyMetric = prometheus.NewDesc(
 		prometheus.BuildFQName("pinger", "", "latency_seconds"),
 		"Average latency in seconds",
-		[]string{"host"},
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	duplicatesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "packets_duplicate_count"),
+		"Total duplicate packets received",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	reorderedMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "packets_reordered_count"),
+		"Total packets received out of order",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	lateMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "packets_late_count"),
+		"Total packets received after the request timed out",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	droppedMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "packets_dropped_count"),
+		"Total packets dropped because they weren't processed in time",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	hopPacketsSentMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "hop", "packets_sent_count"),
+		"Total packets sent to a hop on the path to the host",
+		[]string{"host", "netns", "dscp", "hop", "hop_addr"},
+		nil,
+	)
+	hopPacketsReceivedMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "hop", "packets_received_count"),
+		"Total packets received from a hop on the path to the host",
+		[]string{"host", "netns", "dscp", "hop", "hop_addr"},
+		nil,
+	)
+	hopLatencyMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "hop", "latency_seconds"),
+		"Average latency in seconds of a hop on the path to the host",
+		[]string{"host", "netns", "dscp", "hop", "hop_addr"},
+		nil,
+	)
+	routeChangesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "route_changes_count"),
+		"Total number of route changes to the host",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	icmpErrorsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "icmp_errors_count"),
+		"Total icmp errors received in response to packets sent to the host",
+		[]string{"host", "netns", "dscp", "type", "reason"},
+		nil,
+	)
+	intervalMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "interval_seconds"),
+		"Current interval between two packets sent to the host in seconds",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	trainsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "train", "count"),
+		"Total packet trains sent to the host",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	trainLostMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "train", "packets_lost_count"),
+		"Total packets lost within a packet train",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	trainSpreadMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "train", "latency_spread_seconds"),
+		"Median difference between the highest and lowest latency within a packet train in seconds",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	trainDispersionMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "train", "dispersion_seconds"),
+		"Median time between the arrival of two replies within a packet train in seconds",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	pathMTUMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "path_mtu_bytes"),
+		"Path MTU to the host in bytes",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	targetInfoMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "target_info"),
+		"Payload settings of the packets sent to the host",
+		[]string{"host", "netns", "dscp", "size", "pattern", "ttl"},
+		nil,
+	)
+	receivedTTLMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "received_ttl"),
+		"TTL (IPv4) or hop limit (IPv6) of the last packet received from the host",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	returnHopsMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "return_hops"),
+		"Number of hops on the path from the host, inferred from the received TTL",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	ttlChangesMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "ttl_changes_count"),
+		"Total number of changes in the TTL of the packets received from the host",
+		[]string{"host", "netns", "dscp"},
+		nil,
+	)
+	routeInfoMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("pinger", "", "route_info"),
+		"Current route to the host",
+		[]string{"host", "netns", "dscp", "route"},
 		nil,
 	)
 )
@@ -43,14 +164,71 @@ func (c Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- packetsSentMetric
 	ch <- packetsReceivedMetric
 	ch <- latencyMetric
+	ch <- duplicatesMetric
+	ch <- reorderedMetric
+	ch <- lateMetric
+	ch <- droppedMetric
+	ch <- icmpErrorsMetric
+	ch <- hopPacketsSentMetric
+	ch <- hopPacketsReceivedMetric
+	ch <- hopLatencyMetric
+	ch <- routeChangesMetric
+	ch <- routeInfoMetric
+	ch <- pathMTUMetric
+	ch <- targetInfoMetric
+	ch <- intervalMetric
+	ch <- trainsMetric
+	ch <- trainLostMetric
+	ch <- trainSpreadMetric
+	ch <- trainDispersionMetric
+	ch <- receivedTTLMetric
+	ch <- returnHopsMetric
+	ch <- ttlChangesMetric
 }
 
 // Collect implements the Prometheus Collector interface
 func (c Collector) Collect(ch chan<- prometheus.Metric) {
 	for name, statistics := range c.Targets.Statistics() {
 		c.Logger.Info("statistics", "target", name, "sent", statistics.Sent, "rcvd", statistics.Received, "latency", statistics.Latency)
-		ch <- prometheus.MustNewConstMetric(packetsSentMetric, prometheus.CounterValue, float64(statistics.Sent), name)
-		ch <- prometheus.MustNewConstMetric(packetsReceivedMetric, prometheus.CounterValue, float64(statistics.Received), name)
-		ch <- prometheus.MustNewConstMetric(latencyMetric, prometheus.GaugeValue, statistics.Latency.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(packetsSentMetric, prometheus.CounterValue, float64(statistics.Sent), name, statistics.Netns, statistics.DSCP)
+		ch <- prometheus.MustNewConstMetric(packetsReceivedMetric, prometheus.CounterValue, float64(statistics.Received), name, statistics.Netns, statistics.DSCP)
+		ch <- prometheus.MustNewConstMetric(latencyMetric, prometheus.GaugeValue, statistics.Latency.Seconds(), name, statistics.Netns, statistics.DSCP)
+		ch <- prometheus.MustNewConstMetric(duplicatesMetric, prometheus.CounterValue, float64(statistics.Duplicates), name, statistics.Netns, statistics.DSCP)
+		ch <- prometheus.MustNewConstMetric(reorderedMetric, prometheus.CounterValue, float64(statistics.Reordered), name, statistics.Netns, statistics.DSCP)
+		ch <- prometheus.MustNewConstMetric(lateMetric, prometheus.CounterValue, float64(statistics.Late), name, statistics.Netns, statistics.DSCP)
+		ch <- prometheus.MustNewConstMetric(droppedMetric, prometheus.CounterValue, float64(statistics.Dropped), name, statistics.Netns, statistics.DSCP)
+		ch <- prometheus.MustNewConstMetric(intervalMetric, prometheus.GaugeValue, statistics.Interval.Seconds(), name, statistics.Netns, statistics.DSCP)
+		ch <- prometheus.MustNewConstMetric(targetInfoMetric, prometheus.GaugeValue, 1, name, statistics.Netns, statistics.DSCP, strconv.Itoa(statistics.Size), statistics.Pattern, strconv.Itoa(int(statistics.TTL)))
+		for icmpError, count := range statistics.Errors {
+			ch <- prometheus.MustNewConstMetric(icmpErrorsMetric, prometheus.CounterValue, float64(count), name, statistics.Netns, statistics.DSCP, icmpError.Type, icmpError.Reason)
+		}
+		for i, hop := range statistics.Hops {
+			hopNumber := strconv.Itoa(i + 1)
+			hopAddr := "*"
+			if hop.Addr != nil {
+				hopAddr = hop.Addr.String()
+			}
+			ch <- prometheus.MustNewConstMetric(hopPacketsSentMetric, prometheus.CounterValue, float64(hop.Sent), name, statistics.Netns, statistics.DSCP, hopNumber, hopAddr)
+			ch <- prometheus.MustNewConstMetric(hopPacketsReceivedMetric, prometheus.CounterValue, float64(hop.Received), name, statistics.Netns, statistics.DSCP, hopNumber, hopAddr)
+			ch <- prometheus.MustNewConstMetric(hopLatencyMetric, prometheus.GaugeValue, hop.Latency.Seconds(), name, statistics.Netns, statistics.DSCP, hopNumber, hopAddr)
+		}
+		if statistics.Route != "" {
+			ch <- prometheus.MustNewConstMetric(routeChangesMetric, prometheus.CounterValue, float64(statistics.RouteChanges), name, statistics.Netns, statistics.DSCP)
+			ch <- prometheus.MustNewConstMetric(routeInfoMetric, prometheus.GaugeValue, 1, name, statistics.Netns, statistics.DSCP, statistics.Route)
+		}
+		if statistics.TrainSize > 1 {
+			ch <- prometheus.MustNewConstMetric(trainsMetric, prometheus.CounterValue, float64(statistics.Trains), name, statistics.Netns, statistics.DSCP)
+			ch <- prometheus.MustNewConstMetric(trainLostMetric, prometheus.CounterValue, float64(statistics.TrainLost), name, statistics.Netns, statistics.DSCP)
+			ch <- prometheus.MustNewConstMetric(trainSpreadMetric, prometheus.GaugeValue, statistics.TrainSpread.Seconds(), name, statistics.Netns, statistics.DSCP)
+			ch <- prometheus.MustNewConstMetric(trainDispersionMetric, prometheus.GaugeValue, statistics.TrainDispersion.Seconds(), name, statistics.Netns, statistics.DSCP)
+		}
+		if statistics.PathMTU != 0 {
+			ch <- prometheus.MustNewConstMetric(pathMTUMetric, prometheus.GaugeValue, float64(statistics.PathMTU), name, statistics.Netns, statistics.DSCP)
+		}
+		if statistics.ReceivedTTL != 0 {
+			ch <- prometheus.MustNewConstMetric(receivedTTLMetric, prometheus.GaugeValue, float64(statistics.ReceivedTTL), name, statistics.Netns, statistics.DSCP)
+			ch <- prometheus.MustNewConstMetric(returnHopsMetric, prometheus.GaugeValue, float64(statistics.ReturnHops), name, statistics.Netns, statistics.DSCP)
+			ch <- prometheus.MustNewConstMetric(ttlChangesMetric, prometheus.CounterValue, float64(statistics.TTLChanges), name, statistics.Netns, statistics.DSCP)
+		}
 	}
 }
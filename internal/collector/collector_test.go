@@ -3,34 +3,68 @@ package collector
 import (
 	"bytes"
 	"log/slog"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/clambin/pinger/internal/pinger"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestPinger_Collect(t *testing.T) {
 	targets := fakeTargets(pinger.Statistics{
-		Sent:     20,
-		Received: 10,
-		Latency:  200 * time.Millisecond,
+		Sent:       20,
+		Received:   10,
+		Latency:    200 * time.Millisecond,
+		Duplicates: 2,
+		Reordered:  1,
+		Late:       3,
+		Dropped:    4,
+		Size:       56,
+		Pattern:    "zeros",
+		TTL:        64,
+		Interval:   250 * time.Millisecond,
 	})
 	p := Collector{Targets: targets, Logger: slog.Default()}
 
 	err := testutil.CollectAndCompare(p, bytes.NewBufferString(`
+# HELP pinger_interval_seconds Current interval between two packets sent to the host in seconds
+# TYPE pinger_interval_seconds gauge
+pinger_interval_seconds{dscp="",host="localhost",netns=""} 0.25
+
 # HELP pinger_latency_seconds Average latency in seconds
 # TYPE pinger_latency_seconds gauge
-pinger_latency_seconds{host="localhost"} 0.2
+pinger_latency_seconds{dscp="",host="localhost",netns=""} 0.2
 
 # HELP pinger_packets_sent_count Total packets sent
 # TYPE pinger_packets_sent_count counter
-pinger_packets_sent_count{host="localhost"} 20
+pinger_packets_sent_count{dscp="",host="localhost",netns=""} 20
 
 # HELP pinger_packets_received_count Total packet received
 # TYPE pinger_packets_received_count counter
-pinger_packets_received_count{host="localhost"} 10
+pinger_packets_received_count{dscp="",host="localhost",netns=""} 10
+
+# HELP pinger_packets_duplicate_count Total duplicate packets received
+# TYPE pinger_packets_duplicate_count counter
+pinger_packets_duplicate_count{dscp="",host="localhost",netns=""} 2
+
+# HELP pinger_packets_dropped_count Total packets dropped because they weren't processed in time
+# TYPE pinger_packets_dropped_count counter
+pinger_packets_dropped_count{dscp="",host="localhost",netns=""} 4
+
+# HELP pinger_packets_late_count Total packets received after the request timed out
+# TYPE pinger_packets_late_count counter
+pinger_packets_late_count{dscp="",host="localhost",netns=""} 3
+
+# HELP pinger_packets_reordered_count Total packets received out of order
+# TYPE pinger_packets_reordered_count counter
+pinger_packets_reordered_count{dscp="",host="localhost",netns=""} 1
+
+# HELP pinger_target_info Payload settings of the packets sent to the host
+# TYPE pinger_target_info gauge
+pinger_target_info{dscp="",host="localhost",netns="",pattern="zeros",size="56",ttl="64"} 1
 `))
 	require.NoError(t, err)
 }
@@ -44,3 +78,142 @@ func (f fakeTargets) Statistics() map[string]pinger.Statistics {
 		"localhost": pinger.Statistics(f),
 	}
 }
+
+func TestPinger_Collect_Path(t *testing.T) {
+	targets := fakeTargets(pinger.Statistics{
+		Sent:         1,
+		Received:     1,
+		Latency:      20 * time.Millisecond,
+		Route:        "0badcafe",
+		RouteChanges: 1,
+		Netns:        "vrf1",
+		Hops: []pinger.HopStatistics{
+			{Sent: 1, Received: 1, Latency: 10 * time.Millisecond, Addr: net.ParseIP("192.168.0.1")},
+			{Sent: 1},
+			{Sent: 1, Received: 1, Latency: 20 * time.Millisecond, Addr: net.ParseIP("127.0.0.1")},
+		},
+	})
+	p := Collector{Targets: targets, Logger: slog.New(slog.DiscardHandler)}
+
+	err := testutil.CollectAndCompare(p, bytes.NewBufferString(`
+# HELP pinger_hop_latency_seconds Average latency in seconds of a hop on the path to the host
+# TYPE pinger_hop_latency_seconds gauge
+pinger_hop_latency_seconds{dscp="",hop="1",hop_addr="192.168.0.1",host="localhost",netns="vrf1"} 0.01
+pinger_hop_latency_seconds{dscp="",hop="2",hop_addr="*",host="localhost",netns="vrf1"} 0
+pinger_hop_latency_seconds{dscp="",hop="3",hop_addr="127.0.0.1",host="localhost",netns="vrf1"} 0.02
+
+# HELP pinger_hop_packets_received_count Total packets received from a hop on the path to the host
+# TYPE pinger_hop_packets_received_count counter
+pinger_hop_packets_received_count{dscp="",hop="1",hop_addr="192.168.0.1",host="localhost",netns="vrf1"} 1
+pinger_hop_packets_received_count{dscp="",hop="2",hop_addr="*",host="localhost",netns="vrf1"} 0
+pinger_hop_packets_received_count{dscp="",hop="3",hop_addr="127.0.0.1",host="localhost",netns="vrf1"} 1
+
+# HELP pinger_hop_packets_sent_count Total packets sent to a hop on the path to the host
+# TYPE pinger_hop_packets_sent_count counter
+pinger_hop_packets_sent_count{dscp="",hop="1",hop_addr="192.168.0.1",host="localhost",netns="vrf1"} 1
+pinger_hop_packets_sent_count{dscp="",hop="2",hop_addr="*",host="localhost",netns="vrf1"} 1
+pinger_hop_packets_sent_count{dscp="",hop="3",hop_addr="127.0.0.1",host="localhost",netns="vrf1"} 1
+
+# HELP pinger_route_changes_count Total number of route changes to the host
+# TYPE pinger_route_changes_count counter
+pinger_route_changes_count{dscp="",host="localhost",netns="vrf1"} 1
+
+# HELP pinger_route_info Current route to the host
+# TYPE pinger_route_info gauge
+pinger_route_info{dscp="",host="localhost",netns="vrf1",route="0badcafe"} 1
+`), "pinger_hop_latency_seconds", "pinger_hop_packets_received_count", "pinger_hop_packets_sent_count", "pinger_route_changes_count", "pinger_route_info")
+	require.NoError(t, err)
+}
+
+func TestPinger_Collect_Errors(t *testing.T) {
+	targets := fakeTargets(pinger.Statistics{
+		Sent: 20,
+		DSCP: "EF",
+		Errors: map[pinger.ICMPError]int{
+			{Type: "destination unreachable", Reason: "communication administratively prohibited"}: 15,
+			{Type: "destination unreachable", Reason: "host unreachable"}:                          5,
+		},
+	})
+	p := Collector{Targets: targets, Logger: slog.New(slog.DiscardHandler)}
+
+	err := testutil.CollectAndCompare(p, bytes.NewBufferString(`
+# HELP pinger_icmp_errors_count Total icmp errors received in response to packets sent to the host
+# TYPE pinger_icmp_errors_count counter
+pinger_icmp_errors_count{dscp="EF",host="localhost",netns="",reason="communication administratively prohibited",type="destination unreachable"} 15
+pinger_icmp_errors_count{dscp="EF",host="localhost",netns="",reason="host unreachable",type="destination unreachable"} 5
+`), "pinger_icmp_errors_count")
+	require.NoError(t, err)
+}
+
+func TestPinger_Collect_PathMTU(t *testing.T) {
+	targets := fakeTargets(pinger.Statistics{
+		Sent:    20,
+		PathMTU: 1400,
+	})
+	p := Collector{Targets: targets, Logger: slog.New(slog.DiscardHandler)}
+
+	err := testutil.CollectAndCompare(p, bytes.NewBufferString(`
+# HELP pinger_path_mtu_bytes Path MTU to the host in bytes
+# TYPE pinger_path_mtu_bytes gauge
+pinger_path_mtu_bytes{dscp="",host="localhost",netns=""} 1400
+`), "pinger_path_mtu_bytes")
+	require.NoError(t, err)
+}
+
+func TestPinger_Collect_ReceivedTTL(t *testing.T) {
+	targets := fakeTargets(pinger.Statistics{
+		Sent:        20,
+		ReceivedTTL: 58,
+		ReturnHops:  6,
+		TTLChanges:  2,
+	})
+	p := Collector{Targets: targets, Logger: slog.New(slog.DiscardHandler)}
+
+	err := testutil.CollectAndCompare(p, bytes.NewBufferString(`
+# HELP pinger_received_ttl TTL (IPv4) or hop limit (IPv6) of the last packet received from the host
+# TYPE pinger_received_ttl gauge
+pinger_received_ttl{dscp="",host="localhost",netns=""} 58
+# HELP pinger_return_hops Number of hops on the path from the host, inferred from the received TTL
+# TYPE pinger_return_hops gauge
+pinger_return_hops{dscp="",host="localhost",netns=""} 6
+# HELP pinger_ttl_changes_count Total number of changes in the TTL of the packets received from the host
+# TYPE pinger_ttl_changes_count counter
+pinger_ttl_changes_count{dscp="",host="localhost",netns=""} 2
+`), "pinger_received_ttl", "pinger_return_hops", "pinger_ttl_changes_count")
+	require.NoError(t, err)
+
+	// no TTL received: no metrics
+	p = Collector{Targets: fakeTargets(pinger.Statistics{Sent: 20}), Logger: slog.New(slog.DiscardHandler)}
+	assert.Zero(t, testutil.CollectAndCount(p, "pinger_received_ttl", "pinger_return_hops", "pinger_ttl_changes_count"))
+}
+
+func TestPinger_Collect_Train(t *testing.T) {
+	targets := fakeTargets(pinger.Statistics{
+		Sent:            40,
+		TrainSize:       4,
+		Trains:          10,
+		TrainLost:       3,
+		TrainSpread:     5 * time.Millisecond,
+		TrainDispersion: 2 * time.Millisecond,
+	})
+	p := Collector{Targets: targets, Logger: slog.New(slog.DiscardHandler)}
+
+	err := testutil.CollectAndCompare(p, bytes.NewBufferString(`
+# HELP pinger_train_count Total packet trains sent to the host
+# TYPE pinger_train_count counter
+pinger_train_count{dscp="",host="localhost",netns=""} 10
+
+# HELP pinger_train_dispersion_seconds Median time between the arrival of two replies within a packet train in seconds
+# TYPE pinger_train_dispersion_seconds gauge
+pinger_train_dispersion_seconds{dscp="",host="localhost",netns=""} 0.002
+
+# HELP pinger_train_latency_spread_seconds Median difference between the highest and lowest latency within a packet train in seconds
+# TYPE pinger_train_latency_spread_seconds gauge
+pinger_train_latency_spread_seconds{dscp="",host="localhost",netns=""} 0.005
+
+# HELP pinger_train_packets_lost_count Total packets lost within a packet train
+# TYPE pinger_train_packets_lost_count counter
+pinger_train_packets_lost_count{dscp="",host="localhost",netns=""} 3
+`), "pinger_train_count", "pinger_train_dispersion_seconds", "pinger_train_latency_spread_seconds", "pinger_train_packets_lost_count")
+	require.NoError(t, err)
+}
@@ -1,8 +1,13 @@
 package configuration
 
 import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"math"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/clambin/pinger/internal/pinger"
 	"github.com/spf13/viper"
@@ -16,12 +21,28 @@ type Configuration struct {
 
 func GetTargets(v *viper.Viper, args []string) pinger.Targets {
 	if hosts := os.Getenv("HOSTS"); hosts != "" {
-		return getTargetsFromEnv(hosts)
+		return uniqueNames(getTargetsFromEnv(hosts))
 	}
 	if len(args) > 0 {
-		return getTargetsFromArgs(args)
+		return uniqueNames(getTargetsFromArgs(args))
 	}
-	return getTargetsFromViper(v)
+	return uniqueNames(getTargetsFromViper(v))
+}
+
+// uniqueNames removes the targets with the same name as an earlier target. The statistics of a target are reported
+// by name, so targets with the same name would overwrite each other's statistics.
+func uniqueNames(targets pinger.Targets) pinger.Targets {
+	names := make(map[string]struct{}, len(targets))
+	unique := targets[:0]
+	for _, target := range targets {
+		if _, ok := names[target.Name]; ok {
+			slog.Error("duplicate target name. set a unique name for each target. ignoring", "target", target.Host, "name", target.Name)
+			continue
+		}
+		names[target.Name] = struct{}{}
+		unique = append(unique, target)
+	}
+	return unique
 }
 
 func getTargetsFromEnv(hosts string) pinger.Targets {
@@ -50,19 +71,114 @@ func getTargetsFromViper(v *viper.Viper) pinger.Targets {
 	if viperVal == nil {
 		return targetList
 	}
-	for _, t := range viperVal.([]any) {
-		entry := t.(map[string]any)
-		var host, name string
-		if e := entry["name"]; e != nil {
-			name = e.(string)
+	entries, ok := viperVal.([]any)
+	if !ok {
+		slog.Warn("invalid targets: not a list. ignoring")
+		return targetList
+	}
+	for i, t := range entries {
+		entry, ok := t.(map[string]any)
+		if !ok {
+			slog.Warn("invalid target. ignoring", "index", i, "target", t)
+			continue
 		}
-		if e := entry["host"]; e != nil {
-			host = e.(string)
+		host := getString(entry, "host", "")
+		var socketConfig pinger.SocketConfig
+		var interval, timeout time.Duration
+		socketConfig.Source = getString(entry, "source", host)
+		socketConfig.Interface = getString(entry, "interface", host)
+		socketConfig.Mark = uint32(getInt(entry, "mark", host, 0, math.MaxUint32))
+		socketConfig.Netns = getString(entry, "netns", host)
+		if e := entry["dscp"]; e != nil {
+			socketConfig.DSCP = fmt.Sprint(e)
 		}
-		if name == "" {
-			name = host
+		socketConfig.PMTU = getBool(entry, "pmtu", host)
+		var pattern string
+		if e := entry["pattern"]; e != nil {
+			pattern = fmt.Sprint(e)
 		}
-		targetList = append(targetList, &pinger.Target{Name: name, Host: host})
+		if e := entry["interval"]; e != nil {
+			interval = parseDuration(e, "interval", host)
+		}
+		if e := entry["timeout"]; e != nil {
+			timeout = parseDuration(e, "timeout", host)
+		}
+		targetList = append(targetList, &pinger.Target{
+			Name:         cmp.Or(getString(entry, "name", host), host),
+			Host:         host,
+			Trace:        getBool(entry, "trace", host),
+			Size:         int(getInt(entry, "size", host, 0, pinger.MaxSize)),
+			Pattern:      pattern,
+			TTL:          uint8(getInt(entry, "ttl", host, 1, math.MaxUint8)),
+			Interval:     interval,
+			Timeout:      timeout,
+			Adaptive:     getBool(entry, "adaptive", host),
+			Train:        int(getInt(entry, "train", host, 0, math.MaxInt)),
+			SocketConfig: socketConfig,
+		})
 	}
 	return targetList
 }
+
+// getString returns the string value of a target's key. Values of the wrong type are logged and replaced by "".
+func getString(entry map[string]any, key, host string) string {
+	e, ok := entry[key]
+	if !ok || e == nil {
+		return ""
+	}
+	s, ok := e.(string)
+	if !ok {
+		slog.Warn("invalid value: not a string. ignoring", "target", host, "key", key, "value", e)
+	}
+	return s
+}
+
+// getBool returns the boolean value of a target's key. Values of the wrong type are logged and replaced by false.
+func getBool(entry map[string]any, key, host string) bool {
+	e, ok := entry[key]
+	if !ok || e == nil {
+		return false
+	}
+	b, ok := e.(bool)
+	if !ok {
+		slog.Warn("invalid value: not a boolean. ignoring", "target", host, "key", key, "value", e)
+	}
+	return b
+}
+
+// getInt returns the integer value of a target's key. Values of the wrong type, or outside [minValue, maxValue], are
+// logged and replaced by zero, i.e. the default. It returns an int64, so the range of a uint32 (e.g. mark) can be checked
+// on 32-bit platforms too.
+func getInt(entry map[string]any, key, host string, minValue, maxValue int64) int64 {
+	e, ok := entry[key]
+	if !ok || e == nil {
+		return 0
+	}
+	var i int64
+	switch v := e.(type) {
+	case int:
+		i = int64(v)
+	case int64:
+		// the yaml decoder uses int64 for values that don't fit in an int
+		i = v
+	case uint64:
+		i = int64(min(v, math.MaxInt64))
+	default:
+		slog.Warn("invalid value: not an integer. using default", "target", host, "key", key, "value", e)
+		return 0
+	}
+	if i < minValue || i > maxValue {
+		slog.Warn("invalid value: out of range. using default", "target", host, "key", key, "value", e, "min", minValue, "max", maxValue)
+		return 0
+	}
+	return i
+}
+
+// parseDuration parses a duration (e.g. "200ms"). Invalid durations are logged and replaced by zero, i.e. the default.
+func parseDuration(value any, key, host string) time.Duration {
+	d, err := time.ParseDuration(fmt.Sprint(value))
+	if err != nil {
+		slog.Warn("invalid duration. using default", "target", host, "key", key, "err", err)
+	}
+	return d
+}
@@ -2,8 +2,10 @@ package configuration_test
 
 import (
 	"bytes"
+	"math"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/clambin/pinger/internal/configuration"
 	"github.com/clambin/pinger/internal/pinger"
@@ -19,8 +21,39 @@ targets:
   - name: foo
     host: foo
   - host: bar
+    trace: true
   - name: localhost
     host: 127.0.0.1
+  - name: wan2
+    host: 127.0.0.1
+    source: 127.0.0.2
+    interface: lo
+    mark: 0x2a
+  - name: vrf1
+    host: 127.0.0.1
+    netns: vrf1
+  - name: voice
+    host: 127.0.0.1
+    dscp: EF
+  - name: video
+    host: 127.0.0.1
+    dscp: 34
+  - name: vpn
+    host: 127.0.0.1
+    pmtu: true
+  - name: jumbo
+    host: 127.0.0.1
+    size: 8000
+    pattern: 0xff
+    ttl: 32
+  - name: gateway
+    host: 192.168.0.1
+    interval: 200ms
+    timeout: 1s
+    adaptive: true
+  - name: burst
+    host: 127.0.0.1
+    train: 10
 `
 
 func TestUnmarshal(t *testing.T) {
@@ -39,8 +72,16 @@ func TestUnmarshal(t *testing.T) {
 		Addr:  ":8080",
 		Targets: []*pinger.Target{
 			{Name: "foo", Host: "foo"},
-			{Name: "", Host: "bar"},
+			{Name: "", Host: "bar", Trace: true},
 			{Name: "localhost", Host: "127.0.0.1"},
+			{Name: "wan2", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{Source: "127.0.0.2", Interface: "lo", Mark: 42}},
+			{Name: "vrf1", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{Netns: "vrf1"}},
+			{Name: "voice", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{DSCP: "EF"}},
+			{Name: "video", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{DSCP: "34"}},
+			{Name: "vpn", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{PMTU: true}},
+			{Name: "jumbo", Host: "127.0.0.1", Size: 8000, Pattern: "255", TTL: 32},
+			{Name: "gateway", Host: "192.168.0.1", Interval: 200 * time.Millisecond, Timeout: time.Second, Adaptive: true},
+			{Name: "burst", Host: "127.0.0.1", Train: 10},
 		},
 	}, cfg)
 }
@@ -84,10 +125,18 @@ func TestGetTargets(t *testing.T) {
 			name: "config file",
 			expected: pinger.Targets{
 				{Name: "foo", Host: "foo"},
-				{Name: "bar", Host: "bar"},
+				{Name: "bar", Host: "bar", Trace: true},
 				{Name: "localhost", Host: "127.0.0.1"},
+				{Name: "wan2", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{Source: "127.0.0.2", Interface: "lo", Mark: 42}},
+				{Name: "vrf1", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{Netns: "vrf1"}},
+				{Name: "voice", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{DSCP: "EF"}},
+				{Name: "video", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{DSCP: "34"}},
+				{Name: "vpn", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{PMTU: true}},
+				{Name: "jumbo", Host: "127.0.0.1", Size: 8000, Pattern: "255", TTL: 32},
+				{Name: "gateway", Host: "192.168.0.1", Interval: 200 * time.Millisecond, Timeout: time.Second, Adaptive: true},
+				{Name: "burst", Host: "127.0.0.1", Train: 10},
 			},
-			logEntry: "foo,bar,localhost",
+			logEntry: "foo,bar,localhost,wan2,vrf1,voice,video,vpn,jumbo,gateway,burst",
 		},
 	}
 
@@ -109,3 +158,59 @@ func TestGetTargets(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTargets_Invalid(t *testing.T) {
+	const invalid = `
+targets:
+  - host: 127.0.0.1
+    trace: yes please
+    ttl: 256
+    size: 100000
+    mark: -1
+    train: ten
+    interface: 1
+  - not a target
+  - host: 127.0.0.2
+    ttl: 0
+    mark: 4294967296
+  - host: 127.0.0.3
+    mark: 4294967295
+`
+	v := viper.New()
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(bytes.NewBufferString(invalid)))
+
+	// invalid values are replaced by the default & invalid targets are ignored
+	assert.Equal(t, pinger.Targets{
+		{Name: "127.0.0.1", Host: "127.0.0.1"},
+		{Name: "127.0.0.2", Host: "127.0.0.2"},
+		{Name: "127.0.0.3", Host: "127.0.0.3", SocketConfig: pinger.SocketConfig{Mark: math.MaxUint32}},
+	}, configuration.GetTargets(v, nil))
+}
+
+func TestGetTargets_DuplicateNames(t *testing.T) {
+	const duplicates = `
+targets:
+  - host: 127.0.0.1
+    dscp: EF
+  - host: 127.0.0.1
+    dscp: AF41
+  - name: af41
+    host: 127.0.0.1
+    dscp: AF41
+`
+	v := viper.New()
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(bytes.NewBufferString(duplicates)))
+
+	// the second target has the same (default) name as the first one
+	assert.Equal(t, pinger.Targets{
+		{Name: "127.0.0.1", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{DSCP: "EF"}},
+		{Name: "af41", Host: "127.0.0.1", SocketConfig: pinger.SocketConfig{DSCP: "AF41"}},
+	}, configuration.GetTargets(v, nil))
+
+	// the same applies to hosts on the command line
+	assert.Equal(t, pinger.Targets{
+		{Name: "127.0.0.1", Host: "127.0.0.1"},
+	}, configuration.GetTargets(v, []string{"127.0.0.1", "127.0.0.1"}))
+}
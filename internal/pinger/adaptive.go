@@ -0,0 +1,96 @@
+package pinger
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// adaptiveSpeedup is the factor by which the interval is shortened while a target is degraded.
+	adaptiveSpeedup = 4
+	// adaptiveBackoff is the factor by which the interval is lengthened while a target is stable or down.
+	adaptiveBackoff = 4
+	// minAdaptiveInterval is the shortest interval used in adaptive mode.
+	minAdaptiveInterval = 100 * time.Millisecond
+	// degradedHold is how long a target is considered degraded after the last loss or latency deviation.
+	degradedHold = 30 * time.Second
+	// stableAfter is how long a target needs to be healthy before we back off.
+	stableAfter = 5 * time.Minute
+	// downAfter is how long a target needs to be unreachable before we back off.
+	downAfter = time.Minute
+	// baselineMinSamples is the number of replies needed before latency deviations are detected.
+	baselineMinSamples = 10
+)
+
+// adaptiveRate determines the interval between two echo requests to a target, based on the target's health:
+// while a target is degraded (i.e. it loses packets or its latency deviates from its baseline), the interval is
+// shortened, to get a finer resolution. Once the target has been healthy for stableAfter, or unreachable for downAfter,
+// the interval is lengthened.
+//
+// The latency baseline is tracked like TCP's smoothed round-trip time (RFC 6298).
+type adaptiveRate struct {
+	lastDegraded time.Time
+	lastReply    time.Time
+	start        time.Time
+	base         time.Duration
+	srtt         time.Duration
+	rttvar       time.Duration
+	samples      int
+	lock         sync.Mutex
+}
+
+func newAdaptiveRate(interval time.Duration, now time.Time) *adaptiveRate {
+	return &adaptiveRate{
+		base:      interval,
+		start:     now,
+		lastReply: now,
+	}
+}
+
+// reply records an echo reply with the provided latency.
+func (a *adaptiveRate) reply(latency time.Duration, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.lastReply = now
+	if a.samples == 0 {
+		a.srtt = latency
+		a.rttvar = latency / 2
+		a.samples++
+		return
+	}
+	deviation := (latency - a.srtt).Abs()
+	if a.samples >= baselineMinSamples && deviation > 4*a.rttvar+time.Millisecond {
+		a.lastDegraded = now
+	}
+	a.rttvar = (3*a.rttvar + deviation) / 4
+	a.srtt = (7*a.srtt + latency) / 8
+	a.samples++
+}
+
+// loss records a lost echo request.
+func (a *adaptiveRate) loss(now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.lastDegraded = now
+}
+
+// interval returns the interval until the next echo request.
+func (a *adaptiveRate) interval(now time.Time) time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	healthySince := a.start
+	if a.lastDegraded.After(healthySince) {
+		healthySince = a.lastDegraded
+	}
+	switch {
+	case now.Sub(a.lastReply) > downAfter:
+		// down for a long time: more samples won't tell us more
+		return a.base * adaptiveBackoff
+	case !a.lastDegraded.IsZero() && now.Sub(a.lastDegraded) < degradedHold:
+		return max(a.base/adaptiveSpeedup, min(a.base, minAdaptiveInterval))
+	case now.Sub(healthySince) > stableAfter:
+		return a.base * adaptiveBackoff
+	default:
+		return a.base
+	}
+}
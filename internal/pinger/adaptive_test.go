@@ -0,0 +1,58 @@
+package pinger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveRate(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	a := newAdaptiveRate(time.Second, now)
+	assert.Equal(t, time.Second, a.interval(now))
+
+	// establish a baseline
+	for range 2 * baselineMinSamples {
+		now = now.Add(time.Second)
+		a.reply(10*time.Millisecond, now)
+	}
+	assert.Equal(t, time.Second, a.interval(now))
+
+	// latency deviates from the baseline: probe faster
+	now = now.Add(time.Second)
+	a.reply(100*time.Millisecond, now)
+	assert.Equal(t, 250*time.Millisecond, a.interval(now))
+
+	// back to normal once the target is healthy again
+	now = now.Add(degradedHold)
+	a.reply(10*time.Millisecond, now)
+	assert.Equal(t, time.Second, a.interval(now))
+
+	// a loss: probe faster
+	a.loss(now)
+	assert.Equal(t, 250*time.Millisecond, a.interval(now))
+
+	// stable for a long time: back off
+	now = now.Add(stableAfter + time.Second)
+	a.reply(10*time.Millisecond, now)
+	assert.Equal(t, 4*time.Second, a.interval(now))
+
+	// down for a long time: back off
+	a.loss(now)
+	assert.Equal(t, 250*time.Millisecond, a.interval(now))
+	now = now.Add(downAfter + time.Second)
+	a.loss(now)
+	assert.Equal(t, 4*time.Second, a.interval(now))
+}
+
+func TestAdaptiveRate_MinInterval(t *testing.T) {
+	now := time.Now()
+	a := newAdaptiveRate(200*time.Millisecond, now)
+	a.loss(now)
+	assert.Equal(t, minAdaptiveInterval, a.interval(now))
+
+	a = newAdaptiveRate(50*time.Millisecond, now)
+	a.loss(now)
+	assert.Equal(t, 50*time.Millisecond, a.interval(now))
+}
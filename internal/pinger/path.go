@@ -0,0 +1,283 @@
+package pinger
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+)
+
+const (
+	// defaultMaxHops is the maximum number of hops probed when monitoring the path to a target.
+	defaultMaxHops = 30
+	// defaultRouteHistory is the number of distinct routes we remember for a target.
+	defaultRouteHistory = 10
+)
+
+// HopStatistics holds the statistics for one hop on the path to a target.
+type HopStatistics struct {
+	Addr     net.IP
+	Sent     int
+	Received int
+	Latency  time.Duration
+}
+
+// Route is a sequence of hop addresses that was observed on the path to a target.
+type Route struct {
+	// Since is the time the route was first observed.
+	Since time.Time `json:"since"`
+	// Until is the time the route was replaced by another route. Until is zero for the current route.
+	Until time.Time `json:"until,omitzero"`
+	// Hash identifies the route.
+	Hash string `json:"hash"`
+	// Hops holds the address of each hop. Hops that never responded are reported as "*".
+	Hops []string `json:"hops"`
+}
+
+// path continuously probes each hop on the path to a target (like mtr) and keeps per-hop statistics.
+type path struct {
+	outstanding map[ping.SequenceNumber]uint8
+	hops        []*hop
+	routes      []Route
+	length      int
+	maxHops     int
+	changes     int
+	lock        sync.Mutex
+	// complete is true once we know the outcome of the last hop we probe: the target replied, a router reported an
+	// error, or we reached maxHops without a reply from the target. Hops that never respond don't block completion.
+	complete bool
+}
+
+func newPath(maxHops int) *path {
+	return &path{
+		outstanding: make(map[ping.SequenceNumber]uint8),
+		length:      maxHops,
+		maxHops:     maxHops,
+	}
+}
+
+// checkRoute compares the hops observed so far with the current route. If the route changed, it's added to the route history.
+// Routes are only considered once the path is complete, i.e., once we know the full path. Hops that never responded are
+// part of the route as "*".
+func (p *path) checkRoute(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.complete || len(p.hops) < p.length {
+		return
+	}
+	hops := make([]string, p.length)
+	for i, h := range p.hops[:p.length] {
+		hops[i] = "*"
+		if h.addr != nil {
+			hops[i] = h.addr.String()
+		}
+	}
+	hash := routeHash(hops)
+	if len(p.routes) > 0 {
+		current := &p.routes[len(p.routes)-1]
+		if current.Hash == hash {
+			return
+		}
+		current.Until = now
+		p.changes++
+	}
+	// only keep distinct routes: if we've seen this route before, move it to the end of the history
+	p.routes = slices.DeleteFunc(p.routes, func(r Route) bool { return r.Hash == hash })
+	p.routes = append(p.routes, Route{Since: now, Hash: hash, Hops: hops})
+	if len(p.routes) > defaultRouteHistory {
+		p.routes = p.routes[len(p.routes)-defaultRouteHistory:]
+	}
+}
+
+// routeHash returns a short hash that identifies a route.
+func routeHash(hops []string) string {
+	h := fnv.New32a()
+	for _, hop := range hops {
+		_, _ = h.Write([]byte(hop))
+		_, _ = h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// history returns the recently observed routes, oldest first.
+func (p *path) history() []Route {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	routes := make([]Route, len(p.routes))
+	for i, r := range p.routes {
+		routes[i] = r
+		routes[i].Hops = slices.Clone(r.Hops)
+	}
+	return routes
+}
+
+// ttls returns the ttl values to probe in the next cycle.
+// We only probe up to the hop where the target last replied.
+func (p *path) ttls() []uint8 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ttls := make([]uint8, p.length)
+	for i := range ttls {
+		ttls[i] = uint8(i + 1)
+	}
+	return ttls
+}
+
+func (p *path) markRequest(seq ping.SequenceNumber, ttl uint8) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.outstanding[seq] = ttl
+	p.hop(ttl).markRequest(seq)
+}
+
+// cancel removes a probe that wasn't sent.
+func (p *path) cancel(seq ping.SequenceNumber) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ttl, ok := p.outstanding[seq]
+	if !ok {
+		return
+	}
+	delete(p.outstanding, seq)
+	if int(ttl) <= len(p.hops) {
+		p.hops[ttl-1].cancel(seq)
+	}
+}
+
+// markResponse records the response for a probe, including timeouts. It returns false if the response is not for one
+// of the path's probes.
+//
+// The path ends at the first hop where the target replies. If the last hop is a router, or doesn't respond while
+// we're still looking for the target, the path is extended, up to maxHops.
+func (p *path) markResponse(response ping.Response) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ttl, ok := p.outstanding[response.Request.Seq]
+	if !ok {
+		return false
+	}
+	delete(p.outstanding, response.Request.Seq)
+	if int(ttl) > p.length {
+		// path got shorter since we sent the probe
+		return true
+	}
+	p.hop(ttl).markResponse(response)
+
+	switch {
+	case response.ResponseType == ping.ResponseEchoReply && response.From.Equal(response.Request.Target):
+		if int(ttl) < p.length {
+			// the target replied at a lower hop: the path got shorter
+			p.length = int(ttl)
+			p.hops = p.hops[:p.length]
+		}
+		p.complete = true
+	case int(ttl) != p.length:
+		// an intermediate hop: a silent hop is reported as "*"
+	case response.ResponseType == ping.ResponseTimeout && p.complete:
+		// a lost probe: the path didn't change
+	case response.ResponseType == ping.ResponseTimeExceeded || response.ResponseType == ping.ResponseTimeout:
+		// the last hop is a router, or doesn't respond: the path got longer
+		if p.length < p.maxHops {
+			p.length++
+			p.complete = false
+		} else {
+			p.complete = true
+		}
+	default:
+		// an icmp error: the path ends here
+		p.complete = true
+	}
+	return true
+}
+
+// statistics returns the statistics for each hop, the hash of the current route and the number of route changes since the last call.
+// Probes that are outstanding for longer than expiry are lost.
+func (p *path) statistics(expiry time.Duration) ([]HopStatistics, string, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	stats := make([]HopStatistics, len(p.hops))
+	for i, h := range p.hops {
+		stats[i] = h.statistics(expiry)
+	}
+	var hash string
+	if len(p.routes) > 0 {
+		hash = p.routes[len(p.routes)-1].Hash
+	}
+	changes := p.changes
+	p.changes = 0
+	// forget any probes that the hops have given up on
+	for seq, ttl := range p.outstanding {
+		if int(ttl) > len(p.hops) {
+			delete(p.outstanding, seq)
+			continue
+		}
+		if _, ok := p.hops[ttl-1].outstanding[seq]; !ok {
+			delete(p.outstanding, seq)
+		}
+	}
+	return stats, hash, changes
+}
+
+// hop returns the hop for the provided ttl, creating it if needed. The caller must hold the lock.
+func (p *path) hop(ttl uint8) *hop {
+	for len(p.hops) < int(ttl) {
+		p.hops = append(p.hops, &hop{outstanding: make(map[ping.SequenceNumber]time.Time)})
+	}
+	return p.hops[ttl-1]
+}
+
+// hop keeps the statistics for one hop of a path. Its lifecycle is the same as a Target.
+type hop struct {
+	outstanding map[ping.SequenceNumber]time.Time
+	addr        net.IP
+	latencies   []time.Duration
+	sent        int
+	received    int
+}
+
+func (h *hop) markRequest(seq ping.SequenceNumber) {
+	h.sent++
+	h.outstanding[seq] = time.Now()
+}
+
+func (h *hop) cancel(seq ping.SequenceNumber) {
+	if _, ok := h.outstanding[seq]; ok {
+		delete(h.outstanding, seq)
+		h.sent--
+	}
+}
+
+func (h *hop) markResponse(response ping.Response) {
+	if _, ok := h.outstanding[response.Request.Seq]; ok {
+		delete(h.outstanding, response.Request.Seq)
+		if response.ResponseType == ping.ResponseTimeout {
+			// the probe is lost
+			return
+		}
+		h.received++
+		h.addr = response.From
+		h.latencies = append(h.latencies, response.Latency)
+	}
+}
+
+func (h *hop) statistics(expiry time.Duration) HopStatistics {
+	statistics := HopStatistics{
+		Addr:     h.addr,
+		Sent:     h.sent,
+		Received: h.received,
+		Latency:  medianLatency(h.latencies),
+	}
+	for seq, sent := range h.outstanding {
+		if time.Since(sent) > expiry {
+			delete(h.outstanding, seq)
+		}
+	}
+	h.sent = len(h.outstanding)
+	h.received = 0
+	h.latencies = h.latencies[:0]
+	return statistics
+}
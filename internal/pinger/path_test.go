@@ -0,0 +1,182 @@
+package pinger
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPath(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		router := net.ParseIP("192.168.0.1")
+		target := net.ParseIP("10.0.0.1")
+
+		p := newPath(4)
+		assert.Equal(t, []uint8{1, 2, 3, 4}, p.ttls())
+
+		// first hop is a router, the target replies at hop 2. hop 3 & 4 reply too.
+		for seq, ttl := range p.ttls() {
+			p.markRequest(ping.SequenceNumber(seq), ttl)
+		}
+		assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseTimeExceeded, From: router, Latency: 10 * time.Millisecond, Request: ping.Request{Seq: 0}}))
+		assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseEchoReply, From: target, Latency: 20 * time.Millisecond, Request: ping.Request{Target: target, Seq: 1}}))
+		assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseEchoReply, From: target, Latency: 20 * time.Millisecond, Request: ping.Request{Target: target, Seq: 3}}))
+		assert.False(t, p.markResponse(ping.Response{ResponseType: ping.ResponseEchoReply, From: target, Request: ping.Request{Target: target, Seq: 10}}))
+
+		// path is now two hops long
+		assert.Equal(t, []uint8{1, 2}, p.ttls())
+		p.checkRoute(time.Now())
+		hops, route, changes := p.statistics(10 * time.Second)
+		assert.Equal(t, []HopStatistics{
+			{Addr: router, Sent: 1, Received: 1, Latency: 10 * time.Millisecond},
+			{Addr: target, Sent: 1, Received: 1, Latency: 20 * time.Millisecond},
+		}, hops)
+		assert.Equal(t, routeHash([]string{"192.168.0.1", "10.0.0.1"}), route)
+		assert.Zero(t, changes)
+
+		// second hop no longer is the target: path gets longer
+		p.markRequest(4, 1)
+		p.markRequest(5, 2)
+		assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseTimeExceeded, From: router, Latency: 10 * time.Millisecond, Request: ping.Request{Seq: 5}}))
+		assert.Equal(t, []uint8{1, 2, 3}, p.ttls())
+		// we don't know the full route yet: no route change
+		p.checkRoute(time.Now())
+
+		// first hop doesn't reply. it's reported as lost once it expires.
+		hops, route, changes = p.statistics(10 * time.Second)
+		assert.Equal(t, []HopStatistics{
+			{Addr: router, Sent: 1},
+			{Addr: router, Sent: 1, Received: 1, Latency: 10 * time.Millisecond},
+		}, hops)
+		assert.Equal(t, routeHash([]string{"192.168.0.1", "10.0.0.1"}), route)
+		assert.Zero(t, changes)
+		time.Sleep(time.Minute)
+		hops, _, _ = p.statistics(10 * time.Second)
+		assert.Equal(t, []HopStatistics{
+			{Addr: router, Sent: 1},
+			{Addr: router},
+		}, hops)
+		assert.Empty(t, p.outstanding)
+
+		// the target replies at hop 3: new route
+		p.markRequest(6, 3)
+		assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseEchoReply, From: target, Latency: 30 * time.Millisecond, Request: ping.Request{Target: target, Seq: 6}}))
+		p.checkRoute(time.Now())
+		_, route, changes = p.statistics(10 * time.Second)
+		assert.Equal(t, routeHash([]string{"192.168.0.1", "192.168.0.1", "10.0.0.1"}), route)
+		assert.Equal(t, 1, changes)
+		_, _, changes = p.statistics(10 * time.Second)
+		assert.Zero(t, changes)
+
+		history := p.history()
+		require.Len(t, history, 2)
+		assert.Equal(t, []string{"192.168.0.1", "10.0.0.1"}, history[0].Hops)
+		assert.Equal(t, history[1].Since, history[0].Until)
+		assert.Equal(t, []string{"192.168.0.1", "192.168.0.1", "10.0.0.1"}, history[1].Hops)
+		assert.Zero(t, history[1].Until)
+	})
+}
+
+func TestPath_Silent(t *testing.T) {
+	router := net.ParseIP("192.168.0.1")
+	target := net.ParseIP("10.0.0.1")
+	p := newPath(3)
+	p.length = 1
+
+	// hop 1 is a router: look further
+	p.markRequest(1, 1)
+	assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseTimeExceeded, From: router, Request: ping.Request{Target: target, Seq: 1}}))
+	assert.Equal(t, []uint8{1, 2}, p.ttls())
+
+	// hop 2 never responds: look further
+	p.markRequest(2, 1)
+	p.markRequest(3, 2)
+	assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseTimeExceeded, From: router, Request: ping.Request{Target: target, Seq: 2}}))
+	assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseTimeout, Request: ping.Request{Target: target, Seq: 3}}))
+	assert.Equal(t, []uint8{1, 2, 3}, p.ttls())
+	p.checkRoute(time.Now())
+	assert.Empty(t, p.history())
+
+	// the target doesn't respond either: at maxHops, the path is complete
+	p.markRequest(4, 3)
+	assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseTimeout, Request: ping.Request{Target: target, Seq: 4}}))
+	p.checkRoute(time.Now())
+	history := p.history()
+	require.Len(t, history, 1)
+	assert.Equal(t, []string{"192.168.0.1", "*", "*"}, history[0].Hops)
+
+	// the target replies at hop 3: the route changes
+	p.markRequest(5, 3)
+	assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseEchoReply, From: target, Request: ping.Request{Target: target, Seq: 5}}))
+	p.checkRoute(time.Now())
+	history = p.history()
+	require.Len(t, history, 2)
+	assert.Equal(t, []string{"192.168.0.1", "*", "10.0.0.1"}, history[1].Hops)
+
+	// a lost probe to the target doesn't change the path
+	p.markRequest(6, 3)
+	assert.True(t, p.markResponse(ping.Response{ResponseType: ping.ResponseTimeout, Request: ping.Request{Target: target, Seq: 6}}))
+	assert.Equal(t, []uint8{1, 2, 3}, p.ttls())
+
+	// silent hops are reported as lost
+	hops, _, _ := p.statistics(10 * time.Second)
+	assert.Equal(t, HopStatistics{Sent: 1}, hops[1])
+	assert.Empty(t, p.outstanding)
+}
+
+func TestPath_Cancel(t *testing.T) {
+	p := newPath(2)
+	p.markRequest(1, 1)
+	p.markRequest(2, 2)
+
+	// a probe that wasn't sent isn't reported as lost
+	p.cancel(2)
+	p.cancel(3)
+	assert.False(t, p.markResponse(ping.Response{ResponseType: ping.ResponseEchoReply, Request: ping.Request{Seq: 2}}))
+	hops, _, _ := p.statistics(10 * time.Second)
+	assert.Equal(t, []HopStatistics{{Sent: 1}, {}}, hops)
+	assert.Len(t, p.outstanding, 1)
+}
+
+func TestPath_History(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		p := newPath(2)
+		var seq ping.SequenceNumber
+		setRoute := func(hop1 string) {
+			p.markRequest(seq, 1)
+			p.markResponse(ping.Response{ResponseType: ping.ResponseTimeExceeded, From: net.ParseIP(hop1), Request: ping.Request{Seq: seq}})
+			seq++
+			p.markRequest(seq, 2)
+			p.markResponse(ping.Response{ResponseType: ping.ResponseEchoReply, From: net.ParseIP("10.0.0.1"), Request: ping.Request{Target: net.ParseIP("10.0.0.1"), Seq: seq}})
+			seq++
+			p.checkRoute(time.Now())
+			time.Sleep(time.Second)
+		}
+
+		// flapping between two routes only keeps the two distinct routes
+		for range 5 {
+			setRoute("192.168.0.1")
+			setRoute("192.168.0.2")
+		}
+		history := p.history()
+		require.Len(t, history, 2)
+		assert.Equal(t, "192.168.0.1", history[0].Hops[0])
+		assert.Equal(t, "192.168.0.2", history[1].Hops[0])
+		_, _, changes := p.statistics(10 * time.Second)
+		assert.Equal(t, 9, changes)
+
+		// history is limited in size
+		for i := range 2 * defaultRouteHistory {
+			setRoute(fmt.Sprintf("192.168.1.%d", i))
+		}
+		history = p.history()
+		require.Len(t, history, defaultRouteHistory)
+		assert.Equal(t, fmt.Sprintf("192.168.1.%d", 2*defaultRouteHistory-1), history[defaultRouteHistory-1].Hops[0])
+	})
+}
@@ -0,0 +1,78 @@
+package pinger
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+)
+
+const (
+	// defaultPayloadSize is the default size of the payload of the echo requests sent to a target (as ping's default).
+	defaultPayloadSize = 56
+	// defaultPattern is the default content of the payload.
+	defaultPattern = "zeros"
+	// defaultTTL is the default TTL (IPv4) or hop limit (IPv6) of the echo requests sent to a target.
+	defaultTTL = 64
+	// MaxSize is the largest payload that fits in an IPv4 packet, after the IPv4 & ICMP headers and the 20-byte header
+	// added by ping.Socket.
+	MaxSize = 65535 - 20 - 8 - 20
+)
+
+// fillFunc fills the payload of an echo request.
+type fillFunc func(b []byte)
+
+// parsePattern returns the fillFunc for a payload pattern: "zeros", "random", "incrementing" or a fixed byte value
+// (e.g. "0xff" or "255").
+func parsePattern(pattern string) (fillFunc, error) {
+	switch pattern {
+	case "zeros":
+		return func([]byte) {}, nil
+	case "random":
+		return func(b []byte) {
+			for i := range b {
+				b[i] = byte(rand.UintN(256))
+			}
+		}, nil
+	case "incrementing":
+		return func(b []byte) {
+			for i := range b {
+				b[i] = byte(i)
+			}
+		}, nil
+	}
+	value, err := strconv.ParseUint(pattern, 0, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %q", pattern)
+	}
+	return func(b []byte) {
+		for i := range b {
+			b[i] = byte(value)
+		}
+	}, nil
+}
+
+// setPayload validates the target's payload settings and applies the defaults for the ones that aren't set.
+func (t *Target) setPayload() error {
+	if t.Size < 0 || t.Size > MaxSize {
+		return fmt.Errorf("invalid size: %d", t.Size)
+	}
+	if t.Size == 0 {
+		t.Size = defaultPayloadSize
+	}
+	if t.Pattern == "" {
+		t.Pattern = defaultPattern
+	}
+	if t.TTL == 0 {
+		t.TTL = defaultTTL
+	}
+	var err error
+	t.fill, err = parsePattern(t.Pattern)
+	return err
+}
+
+// payload returns the payload for the next echo request sent to the target.
+func (t *Target) payload() []byte {
+	b := make([]byte, t.Size)
+	t.fill(b)
+	return b
+}
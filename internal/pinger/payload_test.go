@@ -0,0 +1,57 @@
+package pinger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr assert.ErrorAssertionFunc
+		want    []byte
+	}{
+		{pattern: "zeros", wantErr: assert.NoError, want: []byte{0, 0, 0, 0}},
+		{pattern: "incrementing", wantErr: assert.NoError, want: []byte{0, 1, 2, 3}},
+		{pattern: "0xff", wantErr: assert.NoError, want: []byte{0xff, 0xff, 0xff, 0xff}},
+		{pattern: "170", wantErr: assert.NoError, want: []byte{0xaa, 0xaa, 0xaa, 0xaa}},
+		{pattern: "random", wantErr: assert.NoError},
+		{pattern: "256", wantErr: assert.Error},
+		{pattern: "ones", wantErr: assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			fill, err := parsePattern(tt.pattern)
+			tt.wantErr(t, err)
+			if err != nil || tt.want == nil {
+				return
+			}
+			b := make([]byte, len(tt.want))
+			fill(b)
+			assert.Equal(t, tt.want, b)
+		})
+	}
+}
+
+func TestTarget_Payload(t *testing.T) {
+	target := Target{Name: "localhost", Host: "127.0.0.1"}
+	require.NoError(t, target.setPayload())
+	assert.Equal(t, make([]byte, defaultPayloadSize), target.payload())
+	assert.Equal(t, uint8(defaultTTL), target.TTL)
+	assert.Equal(t, defaultPattern, target.Pattern)
+
+	target = Target{Name: "localhost", Host: "127.0.0.1", Size: 1400, Pattern: "random", TTL: 8}
+	require.NoError(t, target.setPayload())
+	assert.Len(t, target.payload(), 1400)
+	assert.NotEqual(t, target.payload(), target.payload())
+	assert.Equal(t, uint8(8), target.TTL)
+
+	target = Target{Name: "localhost", Host: "127.0.0.1", Size: -1}
+	assert.Error(t, target.setPayload())
+	target = Target{Name: "localhost", Host: "127.0.0.1", Size: MaxSize + 1}
+	assert.Error(t, target.setPayload())
+	target = Target{Name: "localhost", Host: "127.0.0.1", Pattern: "ones"}
+	assert.Error(t, target.setPayload())
+}
@@ -10,53 +10,173 @@ import (
 	"github.com/clambin/pinger/ping"
 )
 
+// responseBufferSize is the number of responses buffered for each target. Each target reads its own responses, so this
+// only needs to hold the responses for one cycle of path probes, plus a few echo replies.
+const responseBufferSize = 64
+
 type Socket interface {
-	Send(target net.IP, seq ping.SequenceNumber, ttl uint8, payload []byte) error
 	Serve(ctx context.Context)
-	Read(ctx context.Context) (ping.Response, error)
 	Resolve(name string) (net.IP, error)
+	ReceivesErrors(target net.IP) bool
+	// Register returns a Handle that only receives the responses to its own requests.
+	Register(size int) Handle
+}
+
+// A Handle sends the echo requests for one target and receives their responses. See ping.Handle.
+type Handle interface {
+	SendWithTimeout(target net.IP, seq ping.SequenceNumber, ttl uint8, payload []byte, timeout time.Duration) error
+	// SendBatch sends the packets with as few system calls as possible. It returns the number of packets sent.
+	SendBatch(packets []ping.Packet) (int, error)
+	Read(ctx context.Context) (ping.Response, error)
+	Dropped() uint64
+	Close()
 }
 
-var _ Socket = &ping.Socket{}
+var _ Handle = &ping.Handle{}
+
+// NewSocket returns a Socket that sends and receives packets through the provided ping.Socket.
+func NewSocket(s *ping.Socket) Socket {
+	return pingSocket{Socket: s}
+}
+
+// pingSocket adapts a ping.Socket to the Socket interface.
+type pingSocket struct {
+	*ping.Socket
+}
 
+func (s pingSocket) Register(size int) Handle {
+	return s.Socket.Register(size)
+}
+
+// SocketFactory creates a Socket with the provided SocketConfig.
+type SocketFactory func(cfg SocketConfig) (Socket, error)
+
+// TargetPinger pings a set of targets. Targets with the same SocketConfig share a Socket, but each target has its
+// own Handle, so a host may be pinged by several targets (e.g. with a different payload size or TTL).
 type TargetPinger struct {
-	targets map[string]*Target
-	socket  Socket
-	logger  *slog.Logger
+	sockets   []Socket
+	targets   []*Target
+	logger    *slog.Logger
+	scheduler scheduler
+	batchSize int
 }
 
-func New(targets Targets, s Socket, logger *slog.Logger) *TargetPinger {
+func New(targets Targets, newSocket SocketFactory, logger *slog.Logger, opts ...Option) *TargetPinger {
 	mp := TargetPinger{
-		targets: make(map[string]*Target, len(targets)),
-		socket:  s,
-		logger:  logger,
+		logger: logger,
+	}
+	for _, opt := range opts {
+		opt(&mp)
 	}
 
+	sockets := make(map[SocketConfig]Socket)
 	for _, target := range targets {
+		if err := target.setPayload(); err != nil {
+			logger.Error("invalid payload. omitting from target list", "target", target.Host, "err", err)
+			continue
+		}
+		if err := target.setTiming(); err != nil {
+			logger.Error("invalid timing. omitting from target list", "target", target.Host, "err", err)
+			continue
+		}
+		socket, ok := sockets[target.SocketConfig]
+		if !ok {
+			var err error
+			if socket, err = newSocket(target.SocketConfig); err != nil {
+				logger.Error("failed to create socket. omitting from target list", "target", target.Host, "err", err)
+				continue
+			}
+			sockets[target.SocketConfig] = socket
+			mp.sockets = append(mp.sockets, socket)
+		}
 		var err error
-		target.addr, err = s.Resolve(target.Host)
+		target.addr, err = socket.Resolve(target.Host)
 		if err != nil {
 			logger.Error("failed to resolve target. omitting from target list", "target", target.Host, "err", err)
 			continue
 		}
-		mp.targets[target.addr.String()] = target
+		// path monitoring and path MTU discovery need the icmp errors sent back by the routers on the path
+		if (target.Trace || target.PMTU) && !socket.ReceivesErrors(target.addr) {
+			logger.Error("socket doesn't receive icmp errors. disabling path monitoring and path MTU discovery", "target", target.Host)
+			target.Trace, target.PMTU = false, false
+		}
+		if target.Trace {
+			target.path = newPath(defaultMaxHops)
+		}
+		if target.PMTU {
+			target.pmtu = newPMTU(defaultMaxMTU)
+		}
+		target.handle = socket.Register(responseBufferSize)
+		mp.targets = append(mp.targets, target)
 	}
 
 	return &mp
 }
 
 func (tp *TargetPinger) Run(ctx context.Context) {
-	go tp.socket.Serve(ctx)
-	for _, target := range tp.targets {
-		go tp.pingTarget(ctx, target)
+	for _, socket := range tp.sockets {
+		go socket.Serve(ctx)
+	}
+	for i, target := range tp.targets {
+		// pingTarget applies the pps cap itself, so the packets of a train are sent back-to-back
+		go tp.pingTarget(ctx, target, tp.scheduler.offset(i, len(tp.targets), target.Interval))
+		handle := tp.scheduler.throttle(ctx, target.handle)
+		if target.path != nil {
+			go tp.tracePath(ctx, handle, target, tp.scheduler.offset(i, len(tp.targets), time.Second))
+		}
+		if target.pmtu != nil {
+			go tp.discoverPMTU(ctx, handle, target)
+		}
+		go tp.readResponses(ctx, target)
 	}
-	go tp.readResponses(ctx)
 	<-ctx.Done()
 }
 
-func (tp *TargetPinger) pingTarget(ctx context.Context, target *Target) {
+// pingTarget sends an echo request (or, with Train, a packet train) to the target every interval.
+// The first request is sent after the provided offset.
+func (tp *TargetPinger) pingTarget(ctx context.Context, target *Target, offset time.Duration) {
 	logger := tp.logger.With("target", target.Name)
-	var seq ping.SequenceNumber
+	seqs := make([]ping.SequenceNumber, max(1, target.Train))
+	packets := make([]ping.Packet, 0, len(seqs))
+	next := time.Now().Add(offset)
+	for {
+		if sleep(ctx, time.Until(next)+tp.scheduler.delay(target.Interval)) != nil {
+			return
+		}
+		if tp.scheduler.wait(ctx, len(seqs)) != nil {
+			return
+		}
+		for i := range seqs {
+			seqs[i] = target.nextSeq()
+		}
+		if len(seqs) > 1 {
+			target.markTrain(seqs)
+		}
+		packets = packets[:0]
+		for _, seq := range seqs {
+			// mark the request first: the reply may arrive before Send returns
+			target.markRequest(seq)
+			packets = append(packets, ping.Packet{Target: target.addr, Data: target.payload(), Timeout: target.Timeout, Seq: seq, TTL: target.TTL})
+		}
+		if _, err := tp.send(target.handle, packets); err != nil {
+			logger.Error("ping failed", "err", err)
+		}
+		// in adaptive mode, the interval changes with the target's health.
+		// if we've fallen behind (e.g. because of the pps cap), don't try to catch up.
+		next = next.Add(target.interval())
+		if now := time.Now(); next.Before(now) {
+			next = now
+		}
+	}
+}
+
+// tracePath probes each hop on the path to the target, once per second, and checks if the route to the target has changed.
+// The first probes are sent after the provided offset.
+func (tp *TargetPinger) tracePath(ctx context.Context, handle Handle, target *Target, offset time.Duration) {
+	logger := tp.logger.With("target", target.Name)
+	if sleep(ctx, offset) != nil {
+		return
+	}
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 	for {
@@ -64,34 +184,99 @@ func (tp *TargetPinger) pingTarget(ctx context.Context, target *Target) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if err := tp.socket.Send(target.addr, seq, 64, []byte("payload")); err != nil {
-				logger.Error("ping failed", "err", err)
+			target.path.checkRoute(time.Now())
+			ttls := target.path.ttls()
+			packets := make([]ping.Packet, 0, len(ttls))
+			for _, ttl := range ttls {
+				seq := target.nextSeq()
+				// mark the request first: the response may arrive before Send returns
+				target.path.markRequest(seq, ttl)
+				packets = append(packets, ping.Packet{Target: target.addr, Data: []byte("payload"), Timeout: target.Timeout, Seq: seq, TTL: ttl})
 			}
-			target.markRequest(seq)
-			seq++
+			if n, err := tp.send(handle, packets); err != nil {
+				for _, p := range packets[n:] {
+					target.path.cancel(p.Seq)
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Error("trace failed", "err", err, "ttl", packets[n].TTL)
+			}
+		}
+	}
+}
+
+// send sends the packets in batches of up to batchSize packets. It returns the number of packets sent: if an error
+// occurs, the remaining packets are not sent.
+func (tp *TargetPinger) send(handle Handle, packets []ping.Packet) (int, error) {
+	var sent int
+	for len(packets) > 0 {
+		batch := packets[:min(len(packets), max(1, tp.batchSize))]
+		n, err := handle.SendBatch(batch)
+		sent += n
+		if err != nil {
+			return sent, err
 		}
+		packets = packets[len(batch):]
 	}
+	return sent, nil
 }
 
-func (tp *TargetPinger) readResponses(ctx context.Context) {
+// discoverPMTU determines the path MTU to the target at startup and every defaultPMTUInterval.
+func (tp *TargetPinger) discoverPMTU(ctx context.Context, handle Handle, target *Target) {
+	logger := tp.logger.With("target", target.Name)
+	ticker := time.NewTicker(defaultPMTUInterval)
+	defer ticker.Stop()
 	for {
-		response, err := tp.socket.Read(ctx)
-		if errors.Is(err, context.Canceled) || errors.Is(err, ping.ErrTimeout) {
+		mtu, err := target.pmtu.discover(ctx, handle, target.addr, target.TTL, target.Timeout, target.nextSeq)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("path MTU discovery failed", "err", err)
+		} else if err == nil {
+			logger.Debug("path MTU discovered", "mtu", mtu)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readResponses processes the responses received for a target.
+func (tp *TargetPinger) readResponses(ctx context.Context, target *Target) {
+	defer target.handle.Close()
+	for {
+		response, err := target.handle.Read(ctx)
+		if ctx.Err() != nil || errors.Is(err, ping.ErrSubscriptionClosed) {
 			return
 		}
 		if err != nil {
-			tp.logger.Error("read failed", "err", err)
+			tp.logger.Error("read failed", "target", target.Name, "err", err)
 			continue
 		}
-		if response.ResponseType != ping.ResponseEchoReply {
-			tp.logger.Debug("ignoring non-echo response", "response", response)
+		// path MTU & path probes need all responses, including timeouts
+		if target.pmtu != nil && target.pmtu.markResponse(response) {
 			continue
 		}
-		target, ok := tp.targets[response.From.String()]
-		if !ok {
-			tp.logger.Debug("no target found for response", "response", response)
+		if target.path != nil && target.path.markResponse(response) {
+			continue
+		}
+		if response.ResponseType == ping.ResponseTimeout {
+			target.markTimeout(response)
+			continue
+		}
+		if response.ResponseType == ping.ResponseLate {
+			// late replies to path probes are counted as lost
+			target.markLate(response)
 			continue
 		}
-		target.markResponse(response)
+		switch {
+		case response.ResponseType == ping.ResponseEchoReply:
+			target.markResponse(response)
+		case response.ResponseType == ping.ResponseDuplicate:
+			target.markDuplicate()
+		case response.ResponseType.IsError():
+			target.markError(response)
+		}
 	}
 }
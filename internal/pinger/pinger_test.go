@@ -2,6 +2,7 @@ package pinger
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net"
 	"slices"
@@ -17,50 +18,84 @@ import (
 func TestPinger(t *testing.T) {
 	targets := Targets{
 		&Target{Name: "localhost", Host: "127.0.0.1"},
+		&Target{Name: "traced", Host: "127.0.0.2", Trace: true},
 	}
 
 	s := fakeSocket{latency: 10 * time.Millisecond}
-	p := New(targets, &s, slog.New(slog.DiscardHandler))
+	p := New(targets, func(SocketConfig) (Socket, error) { return &s, nil }, slog.New(slog.DiscardHandler))
 	go p.Run(t.Context())
 
 	assert.Eventually(t, func() bool {
-		return s.received.Load() > 1
+		return s.received.Load() > 2 && len(targets[1].path.ttls()) == 1
 	}, 5*time.Second, 500*time.Millisecond)
 	for name, stats := range targets.Statistics() {
-		assert.Equal(t, "localhost", name)
-		assert.NotZero(t, stats.Received)
-		assert.NotZero(t, stats.Latency)
+		assert.NotZero(t, stats.Received, name)
+		assert.NotZero(t, stats.Latency, name)
+		if name == "traced" {
+			// fakeSocket always replies with an echo reply: the path is one hop long.
+			assert.Len(t, stats.Hops, 1)
+		} else {
+			assert.Empty(t, stats.Hops)
+		}
 	}
 }
 
 var _ Socket = &fakeSocket{}
 
 type fakeSocket struct {
-	packets  packets
 	received atomic.Uint32
 	latency  time.Duration
+	noErrors bool
 }
 
 func (f *fakeSocket) Serve(ctx context.Context) {
 	<-ctx.Done()
 }
 
-func (f *fakeSocket) Send(ip net.IP, seq ping.SequenceNumber, _ uint8, _ []byte) error {
-	f.packets.push(packet{ip: ip, seq: seq, receive: time.Now().Add(f.latency)})
+func (f *fakeSocket) Resolve(s string) (net.IP, error) {
+	return net.ParseIP(s), nil
+}
+
+func (f *fakeSocket) ReceivesErrors(net.IP) bool {
+	return !f.noErrors
+}
+
+func (f *fakeSocket) Register(int) Handle {
+	return &fakeHandle{socket: f}
+}
+
+var _ Handle = &fakeHandle{}
+
+// fakeHandle replies to each echo request with an echo reply, after the socket's latency.
+type fakeHandle struct {
+	socket  *fakeSocket
+	packets packets
+	dropped atomic.Uint64
+}
+
+func (f *fakeHandle) SendWithTimeout(ip net.IP, seq ping.SequenceNumber, ttl uint8, _ []byte, _ time.Duration) error {
+	f.packets.push(packet{ip: ip, seq: seq, ttl: ttl, receive: time.Now().Add(f.socket.latency)})
 	return nil
 }
 
-func (f *fakeSocket) Read(ctx context.Context) (ping.Response, error) {
+func (f *fakeHandle) SendBatch(packets []ping.Packet) (int, error) {
+	for _, p := range packets {
+		f.packets.push(packet{ip: p.Target, seq: p.Seq, ttl: p.TTL, receive: time.Now().Add(f.socket.latency)})
+	}
+	return len(packets), nil
+}
+
+func (f *fakeHandle) Read(ctx context.Context) (ping.Response, error) {
 	ticker := time.NewTicker(10 * time.Millisecond)
 	defer ticker.Stop()
 	for {
 		if pack, ok := f.packets.pop(); ok {
-			f.received.Add(1)
+			f.socket.received.Add(1)
 			r := ping.Response{
 				ResponseType: ping.ResponseEchoReply,
-				Request:      ping.Request{Target: pack.ip, Seq: pack.seq, TTL: 64},
+				Request:      ping.Request{Target: pack.ip, Seq: pack.seq, TTL: pack.ttl},
 				From:         pack.ip,
-				Latency:      f.latency,
+				Latency:      f.socket.latency,
 			}
 			return r, nil
 		}
@@ -72,14 +107,17 @@ func (f *fakeSocket) Read(ctx context.Context) (ping.Response, error) {
 	}
 }
 
-func (f *fakeSocket) Resolve(s string) (net.IP, error) {
-	return net.ParseIP(s), nil
+func (f *fakeHandle) Dropped() uint64 {
+	return f.dropped.Load()
 }
 
+func (f *fakeHandle) Close() {}
+
 type packet struct {
 	receive time.Time
 	ip      net.IP
 	seq     ping.SequenceNumber
+	ttl     uint8
 }
 
 type packets struct {
@@ -115,3 +153,67 @@ func (p *packets) pop() (packet, bool) {
 	p.queue = p.queue[1:]
 	return pack, true
 }
+
+func TestPinger_SocketConfig(t *testing.T) {
+	// ping the same host from two interfaces
+	targets := Targets{
+		&Target{Name: "wan1", Host: "127.0.0.1", SocketConfig: SocketConfig{Interface: "wan1"}},
+		&Target{Name: "wan2", Host: "127.0.0.1", SocketConfig: SocketConfig{Interface: "wan2"}},
+		&Target{Name: "invalid", Host: "127.0.0.1", SocketConfig: SocketConfig{Interface: "invalid"}},
+	}
+	sockets := map[SocketConfig]*fakeSocket{
+		{Interface: "wan1"}: {latency: 10 * time.Millisecond},
+		{Interface: "wan2"}: {latency: 20 * time.Millisecond},
+	}
+	factory := func(cfg SocketConfig) (Socket, error) {
+		if s, ok := sockets[cfg]; ok {
+			return s, nil
+		}
+		return nil, errors.New("invalid interface")
+	}
+	p := New(targets, factory, slog.New(slog.DiscardHandler))
+	assert.Len(t, p.sockets, 2)
+	go p.Run(t.Context())
+
+	assert.Eventually(t, func() bool {
+		return sockets[SocketConfig{Interface: "wan1"}].received.Load() > 2 && sockets[SocketConfig{Interface: "wan2"}].received.Load() > 2
+	}, 5*time.Second, 500*time.Millisecond)
+	stats := targets.Statistics()
+	assert.Equal(t, 10*time.Millisecond, stats["wan1"].Latency)
+	assert.Equal(t, 20*time.Millisecond, stats["wan2"].Latency)
+	assert.Zero(t, stats["invalid"].Sent)
+}
+
+func TestPinger_NoICMPErrors(t *testing.T) {
+	// e.g. a datagram socket on Linux: path monitoring & path MTU discovery are disabled
+	targets := Targets{
+		&Target{Name: "traced", Host: "127.0.0.1", Trace: true, SocketConfig: SocketConfig{PMTU: true}},
+	}
+	s := fakeSocket{noErrors: true}
+	New(targets, func(SocketConfig) (Socket, error) { return &s, nil }, slog.New(slog.DiscardHandler))
+	assert.False(t, targets[0].Trace)
+	assert.Nil(t, targets[0].path)
+	assert.Nil(t, targets[0].pmtu)
+	assert.Empty(t, targets.Routes())
+}
+
+func TestPinger_SameHost(t *testing.T) {
+	// targets with the same host each receive their own responses
+	targets := Targets{
+		&Target{Name: "small", Host: "127.0.0.1"},
+		&Target{Name: "large", Host: "127.0.0.1", Size: 1400, TTL: 32},
+	}
+	s := fakeSocket{latency: 10 * time.Millisecond}
+	p := New(targets, func(SocketConfig) (Socket, error) { return &s, nil }, slog.New(slog.DiscardHandler))
+	assert.Len(t, p.sockets, 1)
+	go p.Run(t.Context())
+
+	// Statistics resets the counters, so add them up
+	received := make(map[string]int)
+	assert.Eventually(t, func() bool {
+		for name, stats := range targets.Statistics() {
+			received[name] += stats.Received
+		}
+		return received["small"] > 0 && received["large"] > 0
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,122 @@
+package pinger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+)
+
+const (
+	// defaultPMTUInterval is how often we determine the path MTU to a target.
+	defaultPMTUInterval = 5 * time.Minute
+	// defaultMaxMTU is the largest path MTU we probe for.
+	defaultMaxMTU = 1500
+	// pmtuRetries is the number of lost probes we accept before a packet size is considered too big.
+	pmtuRetries = 1
+)
+
+// pmtu periodically determines the path MTU to a target. Unlike ping.Socket's DiscoverPMTU, it doesn't read the
+// target's responses itself: readResponses passes them on through markResponse.
+type pmtu struct {
+	pending map[ping.SequenceNumber]chan ping.Response
+	maxMTU  int
+	mtu     int
+	lock    sync.Mutex
+}
+
+func newPMTU(maxMTU int) *pmtu {
+	return &pmtu{
+		pending: make(map[ping.SequenceNumber]chan ping.Response),
+		maxMTU:  maxMTU,
+	}
+}
+
+// markRequest records a probe. The returned channel receives the probe's response.
+func (p *pmtu) markRequest(seq ping.SequenceNumber) <-chan ping.Response {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ch := make(chan ping.Response, 1)
+	p.pending[seq] = ch
+	return ch
+}
+
+// cancel removes a probe that won't receive a response.
+func (p *pmtu) cancel(seq ping.SequenceNumber) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.pending, seq)
+}
+
+// markResponse passes the response to the probe it belongs to. It returns false if the response is not for a probe.
+func (p *pmtu) markResponse(response ping.Response) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ch, ok := p.pending[response.Request.Seq]
+	if ok {
+		delete(p.pending, response.Request.Seq)
+		ch <- response
+	}
+	return ok
+}
+
+// discover determines the path MTU to the target and records it. The probes are sent with the provided TTL.
+//
+// The Socket reports a timeout for each probe without a reply. If that response is lost too (e.g. because the Handle's
+// buffer was full), the probe is considered lost once the grace period after its timeout has passed.
+func (p *pmtu) discover(ctx context.Context, handle Handle, target net.IP, ttl uint8, timeout time.Duration, nextSeq func() ping.SequenceNumber) (int, error) {
+	minMTU := ping.MinMTUv6
+	if target.To4() != nil {
+		minMTU = ping.MinMTUv4
+	}
+	search := ping.NewMTUSearch(minMTU, p.maxMTU, pmtuRetries)
+	for size, done := search.Next(); !done; size, done = search.Next() {
+		seq := nextSeq()
+		ch := p.markRequest(seq)
+		if err := handle.SendWithTimeout(target, seq, ttl, make([]byte, ping.DataLen(target, size)), timeout); err != nil {
+			p.cancel(seq)
+			if errors.Is(err, ping.ErrPacketTooBig) {
+				// larger than the MTU of the outgoing interface
+				search.TooBig(size, 0)
+				continue
+			}
+			return 0, err
+		}
+		deadline := time.NewTimer(timeout + ping.DefaultGracePeriod)
+		select {
+		case <-ctx.Done():
+			deadline.Stop()
+			p.cancel(seq)
+			return 0, ctx.Err()
+		case <-deadline.C:
+			p.cancel(seq)
+			search.Lost(size)
+		case response := <-ch:
+			deadline.Stop()
+			switch response.ResponseType {
+			case ping.ResponseEchoReply:
+				search.Fits(size)
+			case ping.ResponsePacketTooBig:
+				search.TooBig(size, response.MTU)
+			default:
+				// timeouts & other errors: the probe didn't reach the target
+				search.Lost(size)
+			}
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.mtu = search.MTU()
+	return p.mtu, nil
+}
+
+// statistics returns the path MTU found by the last discovery. Zero means the path MTU is unknown.
+func (p *pmtu) statistics() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.mtu
+}
@@ -0,0 +1,81 @@
+package pinger
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPMTU(t *testing.T) {
+	tests := []struct {
+		name      string
+		localMTU  int
+		pathMTU   int
+		blackHole bool
+		dropped   bool
+		want      int
+	}{
+		{name: "fits", localMTU: 1500, pathMTU: 1500, want: 1500},
+		{name: "local interface", localMTU: 1420, pathMTU: 1500, want: 1420},
+		{name: "router reports mtu", localMTU: 1500, pathMTU: 1400, want: 1400},
+		{name: "black hole", localMTU: 1500, pathMTU: 1400, blackHole: true, want: 1400},
+		{name: "timeouts dropped", localMTU: 1500, pathMTU: 1400, blackHole: true, dropped: true, want: 1400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				p := newPMTU(defaultMaxMTU)
+				h := mtuHandle{pmtu: p, localMTU: tt.localMTU, pathMTU: tt.pathMTU, blackHole: tt.blackHole, dropped: tt.dropped}
+				var seq ping.SequenceNumber
+				nextSeq := func() ping.SequenceNumber { seq++; return seq }
+
+				mtu, err := p.discover(t.Context(), &h, net.ParseIP("10.0.0.1"), 32, time.Second, nextSeq)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, mtu)
+				assert.Equal(t, tt.want, p.statistics())
+				assert.Empty(t, p.pending)
+				assert.False(t, p.markResponse(ping.Response{Request: ping.Request{Seq: seq}}))
+				// the probes use the target's TTL
+				assert.Equal(t, []uint8{32}, slices.Compact(h.ttls))
+			})
+		})
+	}
+}
+
+// mtuHandle replies to each probe through pmtu.markResponse, based on the size of the packet.
+type mtuHandle struct {
+	fakeHandle
+	pmtu      *pmtu
+	localMTU  int
+	pathMTU   int
+	blackHole bool
+	// dropped drops the timeouts of the black hole, as if the Handle's buffer was full
+	dropped bool
+	ttls    []uint8
+}
+
+func (m *mtuHandle) SendWithTimeout(ip net.IP, seq ping.SequenceNumber, ttl uint8, data []byte, _ time.Duration) error {
+	// DataLen subtracts the size of the headers from the packet size
+	m.ttls = append(m.ttls, ttl)
+	size := len(data) + defaultMaxMTU - ping.DataLen(ip, defaultMaxMTU)
+	response := ping.Response{ResponseType: ping.ResponseEchoReply, Request: ping.Request{Target: ip, Seq: seq, TTL: ttl}}
+	switch {
+	case size > m.localMTU:
+		return ping.ErrPacketTooBig
+	case size > m.pathMTU && m.blackHole && m.dropped:
+		return nil
+	case size > m.pathMTU && m.blackHole:
+		response.ResponseType = ping.ResponseTimeout
+	case size > m.pathMTU:
+		response.ResponseType = ping.ResponsePacketTooBig
+		response.MTU = m.pathMTU
+	}
+	m.pmtu.markResponse(response)
+	return nil
+}
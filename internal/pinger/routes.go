@@ -0,0 +1,26 @@
+package pinger
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// RoutesHandler returns an http.Handler that reports the recently observed routes of each target with path monitoring enabled.
+// The optional "target" query parameter limits the response to the target with that name.
+func RoutesHandler(targets Targets) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes := targets.Routes()
+		if name := r.URL.Query().Get("target"); name != "" {
+			targetRoutes, ok := routes[name]
+			if !ok {
+				http.Error(w, "target not found", http.StatusNotFound)
+				return
+			}
+			routes = map[string][]Route{name: targetRoutes}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(routes); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package pinger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutesHandler(t *testing.T) {
+	traced := Target{Name: "traced", Host: "127.0.0.1", path: newPath(defaultMaxHops)}
+	traced.path.routes = []Route{{Since: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), Hash: "0badcafe", Hops: []string{"127.0.0.1"}}}
+	targets := Targets{&traced, {Name: "localhost", Host: "127.0.0.1"}}
+	h := RoutesHandler(targets)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantRoutes map[string][]Route
+	}{
+		{"all", "", http.StatusOK, map[string][]Route{"traced": traced.path.routes}},
+		{"single", "?target=traced", http.StatusOK, map[string][]Route{"traced": traced.path.routes}},
+		{"not traced", "?target=localhost", http.StatusNotFound, nil},
+		{"unknown", "?target=foo", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/routes"+tt.query, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var routes map[string][]Route
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&routes))
+			assert.Equal(t, tt.wantRoutes, routes)
+		})
+	}
+}
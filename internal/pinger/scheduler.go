@@ -0,0 +1,139 @@
+package pinger
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+)
+
+// An Option configures a TargetPinger.
+type Option func(*TargetPinger)
+
+// WithJitter delays each echo request by a random duration, up to the provided maximum (capped at the target's interval).
+// This avoids probing in lockstep with other periodic traffic.
+func WithJitter(jitter time.Duration) Option {
+	return func(tp *TargetPinger) {
+		tp.scheduler.jitter = jitter
+	}
+}
+
+// WithMaxPPS limits the number of packets sent per second, across all targets. Zero means no limit.
+func WithMaxPPS(pps int) Option {
+	return func(tp *TargetPinger) {
+		if pps > 0 {
+			tp.scheduler.limiter = newRateLimiter(pps)
+		}
+	}
+}
+
+// WithBatchSize sends up to n packets with one system call (see ping.Socket.SendBatch), e.g. the packets of a packet
+// train or the probes of a path. The default sends each packet separately.
+func WithBatchSize(n int) Option {
+	return func(tp *TargetPinger) {
+		tp.batchSize = n
+	}
+}
+
+// scheduler spreads the packets sent to the targets over time, so pinging many targets doesn't cause bursts of packets.
+type scheduler struct {
+	limiter *rateLimiter
+	jitter  time.Duration
+}
+
+// offset returns the delay before the first echo request to the i-th of n targets, so the requests to all targets are
+// spread evenly over the interval.
+func (s *scheduler) offset(i, n int, interval time.Duration) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	return interval * time.Duration(i) / time.Duration(n)
+}
+
+// delay returns a random delay for the next echo request to a target with the provided interval.
+func (s *scheduler) delay(interval time.Duration) time.Duration {
+	if jitter := min(s.jitter, interval); jitter > 0 {
+		return rand.N(jitter)
+	}
+	return 0
+}
+
+// wait blocks until n packets may be sent, according to the scheduler's packets-per-second limit.
+func (s *scheduler) wait(ctx context.Context, n int) error {
+	if s.limiter == nil {
+		return ctx.Err()
+	}
+	return s.limiter.waitN(ctx, n)
+}
+
+// throttle returns a Handle that honours the scheduler's packets-per-second limit.
+func (s *scheduler) throttle(ctx context.Context, handle Handle) Handle {
+	if s.limiter == nil {
+		return handle
+	}
+	return &throttledHandle{Handle: handle, ctx: ctx, limiter: s.limiter}
+}
+
+// throttledHandle waits for the rate limiter before sending a packet.
+type throttledHandle struct {
+	Handle
+	ctx     context.Context
+	limiter *rateLimiter
+}
+
+func (t *throttledHandle) SendWithTimeout(target net.IP, seq ping.SequenceNumber, ttl uint8, payload []byte, timeout time.Duration) error {
+	if err := t.limiter.waitN(t.ctx, 1); err != nil {
+		return err
+	}
+	return t.Handle.SendWithTimeout(target, seq, ttl, payload, timeout)
+}
+
+func (t *throttledHandle) SendBatch(packets []ping.Packet) (int, error) {
+	if err := t.limiter.waitN(t.ctx, len(packets)); err != nil {
+		return 0, err
+	}
+	return t.Handle.SendBatch(packets)
+}
+
+// rateLimiter spaces out events evenly, so no more than a fixed number of events happen per second.
+type rateLimiter struct {
+	next     time.Time
+	interval time.Duration
+	lock     sync.Mutex
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// waitN blocks until the next n events are allowed. The events may then happen back-to-back.
+func (r *rateLimiter) waitN(ctx context.Context, n int) error {
+	r.lock.Lock()
+	now := time.Now()
+	slot := r.next
+	if slot.Before(now) {
+		slot = now
+	}
+	r.next = slot.Add(time.Duration(n) * r.interval)
+	r.lock.Unlock()
+
+	return sleep(ctx, slot.Sub(now))
+}
+
+// sleep waits for the provided duration, or until the context is canceled.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+package pinger
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_Offset(t *testing.T) {
+	var s scheduler
+	assert.Zero(t, s.offset(0, 4, time.Second))
+	assert.Equal(t, 250*time.Millisecond, s.offset(1, 4, time.Second))
+	assert.Equal(t, 750*time.Millisecond, s.offset(3, 4, time.Second))
+	assert.Zero(t, s.offset(0, 0, time.Second))
+}
+
+func TestScheduler_Delay(t *testing.T) {
+	var s scheduler
+	assert.Zero(t, s.delay(time.Second))
+
+	s.jitter = 100 * time.Millisecond
+	for range 100 {
+		d := s.delay(time.Second)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.Less(t, d, 100*time.Millisecond)
+	}
+	// jitter is capped at the interval
+	s.jitter = time.Minute
+	for range 100 {
+		assert.Less(t, s.delay(time.Second), time.Second)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		r := newRateLimiter(5)
+		start := time.Now()
+		for range 10 {
+			require.NoError(t, r.waitN(t.Context(), 1))
+		}
+		// first event is immediate, the next nine are 200ms apart
+		assert.Equal(t, 1800*time.Millisecond, time.Since(start))
+
+		// idle time doesn't build up credit
+		time.Sleep(time.Minute)
+		start = time.Now()
+		require.NoError(t, r.waitN(t.Context(), 1))
+		require.NoError(t, r.waitN(t.Context(), 1))
+		assert.Equal(t, 200*time.Millisecond, time.Since(start))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		assert.ErrorIs(t, r.waitN(ctx, 1), context.Canceled)
+	})
+}
+
+func TestPinger_Staggered(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		targets := Targets{
+			&Target{Name: "1", Host: "127.0.0.1"},
+			&Target{Name: "2", Host: "127.0.0.2"},
+			&Target{Name: "3", Host: "127.0.0.3"},
+			&Target{Name: "4", Host: "127.0.0.4"},
+		}
+		var s recordingSocket
+		p := New(targets, func(SocketConfig) (Socket, error) { return &s, nil }, slog.New(slog.DiscardHandler))
+		ctx, cancel := context.WithCancel(t.Context())
+		start := time.Now()
+		go p.Run(ctx)
+		time.Sleep(1900 * time.Millisecond)
+		cancel()
+		synctest.Wait()
+
+		// the sends are spread evenly over the interval
+		want := []time.Duration{0, 250, 500, 750, 1000, 1250, 1500, 1750}
+		for i := range want {
+			want[i] *= time.Millisecond
+		}
+		assert.Equal(t, want, s.offsets(start))
+	})
+}
+
+func TestPinger_MaxPPS(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		targets := Targets{
+			&Target{Name: "1", Host: "127.0.0.1", Interval: 100 * time.Millisecond},
+			&Target{Name: "2", Host: "127.0.0.2", Interval: 100 * time.Millisecond},
+		}
+		var s recordingSocket
+		p := New(targets, func(SocketConfig) (Socket, error) { return &s, nil }, slog.New(slog.DiscardHandler), WithMaxPPS(10))
+		ctx, cancel := context.WithCancel(t.Context())
+		start := time.Now()
+		go p.Run(ctx)
+		time.Sleep(time.Second - time.Millisecond)
+		cancel()
+		synctest.Wait()
+
+		// 20 packets per second are requested, but only 10 are sent
+		assert.Len(t, s.offsets(start), 10)
+	})
+}
+
+func TestPinger_BatchSize(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		targets := Targets{
+			&Target{Name: "1", Host: "127.0.0.1", Train: 5},
+		}
+		var s recordingSocket
+		p := New(targets, func(SocketConfig) (Socket, error) { return &s, nil }, slog.New(slog.DiscardHandler), WithBatchSize(2))
+		ctx, cancel := context.WithCancel(t.Context())
+		go p.Run(ctx)
+		time.Sleep(500 * time.Millisecond)
+		cancel()
+		synctest.Wait()
+
+		// the packet train is sent in batches of up to 2 packets
+		assert.Equal(t, []int{2, 2, 1}, s.batches)
+	})
+}
+
+// recordingSocket records the time of each packet sent by its handles, and the size of each batch. It never receives
+// a response.
+type recordingSocket struct {
+	sent    []time.Time
+	batches []int
+	lock    sync.Mutex
+}
+
+func (r *recordingSocket) SendWithTimeout(_ net.IP, _ ping.SequenceNumber, _ uint8, _ []byte, _ time.Duration) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sent = append(r.sent, time.Now())
+	return nil
+}
+
+func (r *recordingSocket) SendBatch(packets []ping.Packet) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for range packets {
+		r.sent = append(r.sent, time.Now())
+	}
+	r.batches = append(r.batches, len(packets))
+	return len(packets), nil
+}
+
+func (r *recordingSocket) Serve(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (r *recordingSocket) Read(ctx context.Context) (ping.Response, error) {
+	<-ctx.Done()
+	return ping.Response{}, ctx.Err()
+}
+
+func (r *recordingSocket) Resolve(name string) (net.IP, error) {
+	return net.ParseIP(name), nil
+}
+
+func (r *recordingSocket) ReceivesErrors(net.IP) bool {
+	return true
+}
+
+func (r *recordingSocket) Register(int) Handle {
+	return r
+}
+
+func (r *recordingSocket) Dropped() uint64 {
+	return 0
+}
+
+func (r *recordingSocket) Close() {}
+
+func (r *recordingSocket) offsets(start time.Time) []time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	offsets := make([]time.Duration, len(r.sent))
+	for i, sent := range r.sent {
+		offsets[i] = sent.Sub(start)
+	}
+	slices.Sort(offsets)
+	return offsets
+}
@@ -2,6 +2,7 @@ package pinger
 
 import (
 	"cmp"
+	"fmt"
 	"log/slog"
 	"net"
 	"slices"
@@ -12,10 +13,59 @@ import (
 	"github.com/clambin/pinger/ping"
 )
 
+// ICMPError identifies an icmp error message received in response to a request, e.g. a destination unreachable.
+type ICMPError struct {
+	Type   string
+	Reason string
+}
+
 type Statistics struct {
-	Sent     int
-	Received int
-	Latency  time.Duration
+	Errors       map[ICMPError]int
+	Route        string
+	Hops         []HopStatistics
+	Sent         int
+	Received     int
+	Latency      time.Duration
+	RouteChanges int
+	// Duplicates is the number of duplicate echo replies received.
+	Duplicates int
+	// Reordered is the number of echo replies received after the reply to a later request.
+	Reordered int
+	// Late is the number of echo replies received after the request timed out. These are not included in Received.
+	Late int
+	// Dropped is the number of responses dropped because they weren't read fast enough. These are not included
+	// in Received.
+	Dropped int
+	// Netns is the network namespace the target is pinged from.
+	Netns string
+	// DSCP is the DiffServ code point of the packets sent to the target.
+	DSCP string
+	// PathMTU is the path MTU to the target, as determined by the last path MTU discovery. Zero if unknown.
+	PathMTU int
+	// Size is the size of the payload of the echo requests sent to the target.
+	Size int
+	// Pattern is the content of the payload of the echo requests sent to the target.
+	Pattern string
+	// TTL is the TTL (IPv4) or hop limit (IPv6) of the echo requests sent to the target.
+	TTL uint8
+	// Interval is the current interval between two echo requests. In adaptive mode, this changes with the target's health.
+	Interval time.Duration
+	// TrainSize is the number of echo requests in each packet train. Zero if the target isn't probed with packet trains.
+	TrainSize int
+	// Trains is the number of packet trains completed.
+	Trains int
+	// TrainLost is the number of packets lost within the completed packet trains.
+	TrainLost int
+	// TrainSpread is the median difference between the highest and lowest latency within a packet train.
+	TrainSpread time.Duration
+	// TrainDispersion is the median time between the arrival of two replies within a packet train.
+	TrainDispersion time.Duration
+	// ReceivedTTL is the TTL (IPv4) or hop limit (IPv6) of the last echo reply received from the target. Zero if unknown.
+	ReceivedTTL uint8
+	// ReturnHops is the number of hops on the path from the target, inferred from ReceivedTTL.
+	ReturnHops int
+	// TTLChanges is the number of times the TTL of the echo replies changed, e.g. because the return path changed.
+	TTLChanges int
 }
 
 var _ slog.LogValuer = Targets{}
@@ -30,6 +80,17 @@ func (t Targets) LogValue() slog.Value {
 	return slog.StringValue(strings.Join(values, ","))
 }
 
+// Routes returns the recently observed routes for each target that has path monitoring enabled.
+func (t Targets) Routes() map[string][]Route {
+	routes := make(map[string][]Route)
+	for _, target := range t {
+		if target.path != nil {
+			routes[target.Name] = target.path.history()
+		}
+	}
+	return routes
+}
+
 func (t Targets) Statistics() map[string]Statistics {
 	stats := make(map[string]Statistics, len(t))
 	for _, target := range t {
@@ -38,15 +99,110 @@ func (t Targets) Statistics() map[string]Statistics {
 	return stats
 }
 
+// SocketConfig determines the Socket used to ping a target. Targets with the same SocketConfig share a Socket.
+type SocketConfig struct {
+	// Source is the IP address to send packets from. If empty, the operating system selects the address.
+	Source string
+	// Interface is the name of the network interface to send packets from. If empty, packets follow the routing table.
+	Interface string
+	// Mark is the firewall mark (SO_MARK) of the packets. With policy routing, this selects the routing table.
+	Mark uint32
+	// Netns is the network namespace to send packets from: either a name (/var/run/netns/<name>) or a path.
+	Netns string
+	// DSCP is the DiffServ code point of the packets: either a name (e.g. "EF", "AF41") or a number.
+	DSCP string
+	// PMTU enables periodic path MTU discovery. This sets the don't fragment flag on all packets sent to the target.
+	PMTU bool
+}
+
+const (
+	// defaultInterval is the default time between two echo requests sent to a target.
+	defaultInterval = time.Second
+	// defaultTimeout is the default time we wait for an echo reply.
+	defaultTimeout = 5 * time.Second
+)
+
 type Target struct {
-	outstanding map[ping.SequenceNumber]time.Time
-	Name        string
-	Host        string
-	addr        net.IP
-	latencies   []time.Duration
-	Sent        int
-	Received    int
-	lock        sync.Mutex
+	SocketConfig `mapstructure:",squash"`
+	outstanding  map[ping.SequenceNumber]time.Time
+	errors       map[ICMPError]int
+	handle       Handle
+	path         *path
+	pmtu         *pmtu
+	fill         fillFunc
+	adaptive     *adaptiveRate
+	trains       map[ping.SequenceNumber]*train
+	trainStats   trainStatistics
+	Name         string
+	Host         string
+	addr         net.IP
+	latencies    []time.Duration
+	Sent         int
+	Received     int
+	duplicates   int
+	reordered    int
+	late         int
+	dropped      uint64
+	ttlChanges   int
+	lock         sync.Mutex
+	seq          ping.SequenceNumber
+	lastReplied  ping.SequenceNumber
+	receivedTTL  uint8
+	replied      bool
+	// Trace enables continuous per-hop monitoring of the path to the target.
+	Trace bool
+	// Size is the size of the payload of each echo request, excluding the 20-byte header added by ping.Socket.
+	// If zero, defaultPayloadSize is used.
+	Size int
+	// Pattern is the content of the payload: "zeros" (default), "random", "incrementing" or a fixed byte value.
+	Pattern string
+	// TTL is the TTL (IPv4) or hop limit (IPv6) of each echo request. If zero, defaultTTL is used.
+	TTL uint8
+	// Interval is the time between two echo requests. If zero, defaultInterval is used.
+	Interval time.Duration
+	// Timeout is how long we wait for an echo reply before the request is reported as lost. If zero, defaultTimeout is used.
+	Timeout time.Duration
+	// Adaptive probes the target more often while it loses packets or its latency deviates from its baseline, and less
+	// often while it's stable or down.
+	Adaptive bool
+	// Train is the number of echo requests sent back-to-back every interval. Zero or one sends a single echo request.
+	Train int
+}
+
+// setTiming validates the target's interval and timeout and applies the defaults for the ones that aren't set.
+func (t *Target) setTiming() error {
+	if t.Interval < 0 {
+		return fmt.Errorf("invalid interval: %s", t.Interval)
+	}
+	if t.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %s", t.Timeout)
+	}
+	if t.Train < 0 {
+		return fmt.Errorf("invalid train: %d", t.Train)
+	}
+	t.Interval = cmp.Or(t.Interval, defaultInterval)
+	t.Timeout = cmp.Or(t.Timeout, defaultTimeout)
+	if t.Adaptive {
+		t.adaptive = newAdaptiveRate(t.Interval, time.Now())
+	}
+	return nil
+}
+
+// interval returns the time until the next echo request to the target.
+func (t *Target) interval() time.Duration {
+	if t.adaptive != nil {
+		return t.adaptive.interval(time.Now())
+	}
+	return t.Interval
+}
+
+// nextSeq returns the next sequence number to use for a request sent to the target.
+func (t *Target) nextSeq() ping.SequenceNumber {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	seq := t.seq
+	t.seq++
+	return seq
 }
 
 func (t *Target) markRequest(seq ping.SequenceNumber) {
@@ -59,28 +215,149 @@ func (t *Target) markRequest(seq ping.SequenceNumber) {
 	t.outstanding[seq] = time.Now()
 }
 
+// markTrain records that the requests with the provided sequence numbers are sent as a packet train.
+func (t *Target) markTrain(seqs []ping.SequenceNumber) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.trains == nil {
+		t.trains = make(map[ping.SequenceNumber]*train)
+	}
+	tr := train{sent: time.Now(), size: len(seqs)}
+	for _, seq := range seqs {
+		t.trains[seq] = &tr
+	}
+}
+
 func (t *Target) markResponse(response ping.Response) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	if _, ok := t.outstanding[response.Request.Seq]; ok {
 		delete(t.outstanding, response.Request.Seq)
+		if tr, ok := t.trains[response.Request.Seq]; ok {
+			delete(t.trains, response.Request.Seq)
+			if tr.reply(response); tr.full() {
+				t.trainStats.complete(tr)
+			}
+		}
 		t.Received++
 		t.latencies = append(t.latencies, response.Latency)
+		if t.adaptive != nil {
+			t.adaptive.reply(response.Latency, time.Now())
+		}
+		if response.TTL != 0 {
+			if t.receivedTTL != 0 && response.TTL != t.receivedTTL {
+				t.ttlChanges++
+			}
+			t.receivedTTL = response.TTL
+		}
+		// sequence numbers wrap around, so compare the difference
+		if t.replied && int16(response.Request.Seq-t.lastReplied) < 0 {
+			t.reordered++
+			return
+		}
+		t.lastReplied = response.Request.Seq
+		t.replied = true
 	}
 }
 
+// markLate records an echo reply received after the request timed out. The request is no longer outstanding,
+// but it's not counted as received either: this allows to distinguish a slow link from a lossy one.
+func (t *Target) markLate(response ping.Response) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.outstanding[response.Request.Seq]; ok {
+		delete(t.outstanding, response.Request.Seq)
+		t.late++
+	}
+}
+
+// markTimeout records an echo request that didn't receive a reply in time. The request remains outstanding, so a late
+// reply is still detected: it's reported as lost once it expires.
+func (t *Target) markTimeout(response ping.Response) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.outstanding[response.Request.Seq]; ok && t.adaptive != nil {
+		t.adaptive.loss(time.Now())
+	}
+}
+
+// markDuplicate records a duplicate echo reply.
+func (t *Target) markDuplicate() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.duplicates++
+}
+
+// markError records an icmp error received in response to a request. The request itself remains outstanding:
+// if no echo reply is received, it's still reported as lost.
+func (t *Target) markError(response ping.Response) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.errors == nil {
+		t.errors = make(map[ICMPError]int)
+	}
+	t.errors[ICMPError{Type: response.ResponseType.String(), Reason: response.Reason()}]++
+}
+
 func (t *Target) statistics() Statistics {
 	t.lock.Lock()
 	defer t.lock.Unlock()
+	timeout := cmp.Or(t.Timeout, defaultTimeout)
+	// trains that have timed out are complete: any requests without a reply are lost
+	timedOut := make(map[*train]struct{})
+	for seq, tr := range t.trains {
+		if tr.done(timeout) {
+			delete(t.trains, seq)
+			timedOut[tr] = struct{}{}
+		}
+	}
+	for tr := range timedOut {
+		t.trainStats.complete(tr)
+	}
 	// calculate statistics
 	statistics := Statistics{
-		Sent:     t.Sent,
-		Received: t.Received,
-		Latency:  t.medianLatency(),
+		Sent:       t.Sent,
+		Received:   t.Received,
+		Latency:    medianLatency(t.latencies),
+		Errors:     t.errors,
+		Duplicates: t.duplicates,
+		Reordered:  t.reordered,
+		Late:       t.late,
+		Netns:      t.Netns,
+		DSCP:       t.DSCP,
+		Size:       t.Size,
+		Pattern:    t.Pattern,
+		TTL:        t.TTL,
+		Interval:   t.interval(),
+		TTLChanges: t.ttlChanges,
+	}
+	if t.receivedTTL != 0 {
+		statistics.ReceivedTTL = t.receivedTTL
+		statistics.ReturnHops = returnHops(t.receivedTTL)
+	}
+	if t.Train > 1 {
+		statistics.TrainSize = t.Train
+		statistics.Trains = t.trainStats.completed
+		statistics.TrainLost = t.trainStats.lost
+		statistics.TrainSpread = medianLatency(t.trainStats.spreads)
+		statistics.TrainDispersion = medianLatency(t.trainStats.dispersions)
 	}
-	// keep up to 10 outstanding requests (i.e., 10 seconds; probably way too much)
+	if t.pmtu != nil {
+		statistics.PathMTU = t.pmtu.statistics()
+	}
+	if t.handle != nil {
+		// the Handle counts all dropped responses: only report the ones since the last call
+		dropped := t.handle.Dropped()
+		statistics.Dropped = int(dropped - t.dropped)
+		t.dropped = dropped
+	}
+	if t.path != nil {
+		statistics.Hops, statistics.Route, statistics.RouteChanges = t.path.statistics(timeout + ping.DefaultGracePeriod)
+	}
+	// requests that are outstanding for longer than the timeout are lost. the Socket reports a reply received during
+	// its grace period as late, so keep them until then.
 	for seq, sent := range t.outstanding {
-		if time.Since(sent) > 10*time.Second {
+		if time.Since(sent) > timeout+ping.DefaultGracePeriod {
 			delete(t.outstanding, seq)
 		}
 	}
@@ -88,16 +365,37 @@ func (t *Target) statistics() Statistics {
 	t.Sent = len(t.outstanding) // keep track of outstanding requests
 	t.Received = 0
 	t.latencies = t.latencies[:0]
+	t.errors = nil
+	t.duplicates = 0
+	t.reordered = 0
+	t.late = 0
+	t.ttlChanges = 0
+	t.trainStats = trainStatistics{}
 	return statistics
 }
 
-func (t *Target) medianLatency() time.Duration {
-	if len(t.latencies) == 0 {
+// initialTTLs are the TTLs commonly used by hosts for the packets they send: 64 (Linux, macOS), 128 (Windows)
+// and 255 (network equipment).
+var initialTTLs = []uint8{64, 128, 255}
+
+// returnHops infers the number of hops a packet passed through from its received TTL, assuming the sender used
+// the lowest common initial TTL that isn't lower than the received TTL.
+func returnHops(ttl uint8) int {
+	for _, initial := range initialTTLs {
+		if ttl <= initial {
+			return int(initial - ttl)
+		}
+	}
+	return 0
+}
+
+func medianLatency(latencies []time.Duration) time.Duration {
+	if len(latencies) == 0 {
 		return 0
 	}
-	slices.Sort(t.latencies)
-	if len(t.latencies)%2 == 0 {
-		return (t.latencies[len(t.latencies)/2-1] + t.latencies[len(t.latencies)/2]) / 2
+	slices.Sort(latencies)
+	if len(latencies)%2 == 0 {
+		return (latencies[len(latencies)/2-1] + latencies[len(latencies)/2]) / 2
 	}
-	return t.latencies[len(t.latencies)/2]
+	return latencies[len(latencies)/2]
 }
@@ -1,12 +1,14 @@
 package pinger
 
 import (
+	"net"
 	"testing"
 	"testing/synctest"
 	"time"
 
 	"github.com/clambin/pinger/ping"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTargets_LogValue(t *testing.T) {
@@ -35,10 +37,10 @@ func TestTarget_Statistics(t *testing.T) {
 		statistics = target.statistics()
 		assert.Equal(t, Statistics{Sent: 2, Received: 0, Latency: 0}, statistics)
 
-		// one packet comes in
+		// one packet comes in. it's received after the reply to a later request.
 		target.markResponse(ping.Response{Latency: 100 * time.Millisecond, Request: ping.Request{Seq: 11}})
 		statistics = target.statistics()
-		assert.Equal(t, Statistics{Sent: 2, Received: 1, Latency: 100 * time.Millisecond}, statistics)
+		assert.Equal(t, Statistics{Sent: 2, Received: 1, Latency: 100 * time.Millisecond, Reordered: 1}, statistics)
 
 		// wait for the last outstanding packet to timeout. should be reported as a loss.
 		time.Sleep(time.Minute)
@@ -58,3 +60,185 @@ func TestTarget_Statistics(t *testing.T) {
 
 	})
 }
+
+func TestTarget_Errors(t *testing.T) {
+	target := Target{Name: "localhost", Host: "127.0.0.1"}
+	target.markRequest(1)
+	target.markRequest(2)
+	target.markRequest(3)
+	from := net.ParseIP("192.168.0.1")
+	target.markError(ping.Response{ResponseType: ping.ResponseDestinationUnreachable, Code: 13, From: from, Request: ping.Request{Seq: 1}})
+	target.markError(ping.Response{ResponseType: ping.ResponseDestinationUnreachable, Code: 13, From: from, Request: ping.Request{Seq: 2}})
+	target.markError(ping.Response{ResponseType: ping.ResponseDestinationUnreachable, Code: 1, From: from, Request: ping.Request{Seq: 3}})
+
+	statistics := target.statistics()
+	assert.Equal(t, 3, statistics.Sent)
+	assert.Zero(t, statistics.Received)
+	assert.Equal(t, map[ICMPError]int{
+		{Type: "destination unreachable", Reason: "communication administratively prohibited"}: 2,
+		{Type: "destination unreachable", Reason: "host unreachable"}:                          1,
+	}, statistics.Errors)
+
+	// errors are reset after each call
+	assert.Empty(t, target.statistics().Errors)
+}
+
+func TestTarget_DuplicatesAndReorders(t *testing.T) {
+	target := Target{Name: "localhost", Host: "127.0.0.1"}
+	for seq := range ping.SequenceNumber(4) {
+		target.markRequest(65534 + seq)
+	}
+	// the reply for 65534 arrives after the reply for 65535. replies for later requests wrap around to 0.
+	for _, seq := range []ping.SequenceNumber{65535, 65534, 0, 1} {
+		target.markResponse(ping.Response{Latency: 10 * time.Millisecond, Request: ping.Request{Seq: seq}})
+	}
+	target.markDuplicate()
+
+	statistics := target.statistics()
+	assert.Equal(t, 4, statistics.Received)
+	assert.Equal(t, 1, statistics.Duplicates)
+	assert.Equal(t, 1, statistics.Reordered)
+
+	// counters are reset
+	statistics = target.statistics()
+	assert.Zero(t, statistics.Duplicates)
+	assert.Zero(t, statistics.Reordered)
+}
+
+func TestTarget_Late(t *testing.T) {
+	target := Target{Name: "localhost", Host: "127.0.0.1"}
+	target.markRequest(1)
+	target.markRequest(2)
+	target.markRequest(3)
+	target.markResponse(ping.Response{ResponseType: ping.ResponseEchoReply, Latency: 10 * time.Millisecond, Request: ping.Request{Seq: 1}})
+	target.markLate(ping.Response{ResponseType: ping.ResponseLate, Latency: 6 * time.Second, Request: ping.Request{Seq: 2}})
+	// a late reply for an unknown request is ignored
+	target.markLate(ping.Response{ResponseType: ping.ResponseLate, Latency: 6 * time.Second, Request: ping.Request{Seq: 10}})
+
+	statistics := target.statistics()
+	assert.Equal(t, 3, statistics.Sent)
+	assert.Equal(t, 1, statistics.Received)
+	assert.Equal(t, 1, statistics.Late)
+	assert.Equal(t, 10*time.Millisecond, statistics.Latency)
+
+	// the late request is no longer outstanding
+	statistics = target.statistics()
+	assert.Equal(t, 1, statistics.Sent)
+	assert.Zero(t, statistics.Late)
+}
+
+func TestTarget_Dropped(t *testing.T) {
+	var h fakeHandle
+	target := Target{Name: "localhost", Host: "127.0.0.1", handle: &h}
+	h.dropped.Store(5)
+	assert.Equal(t, 5, target.statistics().Dropped)
+
+	// only the responses dropped since the last call are reported
+	h.dropped.Add(2)
+	assert.Equal(t, 2, target.statistics().Dropped)
+	assert.Zero(t, target.statistics().Dropped)
+}
+
+func TestTarget_Netns(t *testing.T) {
+	target := Target{Name: "localhost", Host: "127.0.0.1", SocketConfig: SocketConfig{Netns: "vrf1"}}
+	assert.Equal(t, "vrf1", target.statistics().Netns)
+}
+
+func TestTarget_Timeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		target := Target{Name: "gateway", Host: "192.168.0.1", Interval: 200 * time.Millisecond, Timeout: 500 * time.Millisecond}
+		require.NoError(t, target.setTiming())
+		target.markRequest(1)
+		target.markRequest(2)
+		target.markResponse(ping.Response{Latency: time.Millisecond, Request: ping.Request{Seq: 1}})
+
+		// the second request is still outstanding
+		statistics := target.statistics()
+		assert.Equal(t, 2, statistics.Sent)
+		assert.Equal(t, 1, statistics.Received)
+
+		// once the target's timeout expires, a reply is still reported as late during the Socket's grace period
+		time.Sleep(time.Second)
+		statistics = target.statistics()
+		assert.Equal(t, 1, statistics.Sent)
+		target.markLate(ping.Response{ResponseType: ping.ResponseLate, Latency: time.Second, Request: ping.Request{Seq: 2}})
+		statistics = target.statistics()
+		assert.Equal(t, 1, statistics.Sent)
+		assert.Equal(t, 1, statistics.Late)
+
+		// after the grace period, the request is lost
+		target.markRequest(3)
+		time.Sleep(500*time.Millisecond + ping.DefaultGracePeriod + time.Millisecond)
+		statistics = target.statistics()
+		assert.Equal(t, 1, statistics.Sent)
+		assert.Zero(t, statistics.Received+statistics.Late)
+		statistics = target.statistics()
+		assert.Zero(t, statistics.Sent)
+	})
+}
+
+func TestTarget_ReceivedTTL(t *testing.T) {
+	target := Target{Name: "localhost", Host: "127.0.0.1"}
+	for seq, ttl := range []uint8{58, 58, 0, 57, 58} {
+		target.markRequest(ping.SequenceNumber(seq))
+		target.markResponse(ping.Response{Request: ping.Request{Seq: ping.SequenceNumber(seq)}, TTL: ttl})
+	}
+
+	// replies without a TTL are ignored
+	statistics := target.statistics()
+	assert.Equal(t, uint8(58), statistics.ReceivedTTL)
+	assert.Equal(t, 6, statistics.ReturnHops)
+	assert.Equal(t, 2, statistics.TTLChanges)
+
+	// the received TTL is kept, the changes are reset
+	statistics = target.statistics()
+	assert.Equal(t, uint8(58), statistics.ReceivedTTL)
+	assert.Zero(t, statistics.TTLChanges)
+}
+
+func TestReturnHops(t *testing.T) {
+	tests := []struct {
+		ttl  uint8
+		want int
+	}{
+		{ttl: 64, want: 0},
+		{ttl: 50, want: 14},
+		{ttl: 65, want: 63},
+		{ttl: 120, want: 8},
+		{ttl: 129, want: 126},
+		{ttl: 250, want: 5},
+		{ttl: 255, want: 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, returnHops(tt.ttl), tt.ttl)
+	}
+}
+
+func TestTarget_setTiming(t *testing.T) {
+	target := Target{Name: "localhost", Host: "127.0.0.1"}
+	require.NoError(t, target.setTiming())
+	assert.Equal(t, defaultInterval, target.Interval)
+	assert.Equal(t, defaultTimeout, target.Timeout)
+
+	target = Target{Name: "localhost", Host: "127.0.0.1", Interval: -time.Second}
+	assert.Error(t, target.setTiming())
+	target = Target{Name: "localhost", Host: "127.0.0.1", Timeout: -time.Second}
+	assert.Error(t, target.setTiming())
+	target = Target{Name: "localhost", Host: "127.0.0.1", Train: -1}
+	assert.Error(t, target.setTiming())
+}
+
+func TestTarget_Adaptive(t *testing.T) {
+	target := Target{Name: "localhost", Host: "127.0.0.1", Adaptive: true}
+	require.NoError(t, target.setTiming())
+	target.markRequest(1)
+	assert.Equal(t, defaultInterval, target.statistics().Interval)
+
+	// timeouts for requests that aren't outstanding (e.g. path probes) are ignored
+	target.markTimeout(ping.Response{ResponseType: ping.ResponseTimeout, Request: ping.Request{Seq: 2}})
+	assert.Equal(t, defaultInterval, target.statistics().Interval)
+
+	// a lost request: probe faster
+	target.markTimeout(ping.Response{ResponseType: ping.ResponseTimeout, Request: ping.Request{Seq: 1}})
+	assert.Equal(t, defaultInterval/adaptiveSpeedup, target.statistics().Interval)
+}
@@ -0,0 +1,61 @@
+package pinger
+
+import (
+	"slices"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+)
+
+// train is a set of echo requests sent back-to-back to a target. Comparing the replies within a train reveals
+// short bursts of loss or queueing that single probes miss.
+type train struct {
+	sent     time.Time
+	arrivals []time.Time
+	lowest   time.Duration
+	highest  time.Duration
+	size     int
+}
+
+// trainStatistics holds the statistics of the trains completed since the last scrape.
+type trainStatistics struct {
+	spreads     []time.Duration
+	dispersions []time.Duration
+	completed   int
+	lost        int
+}
+
+// reply records the reply to one of the train's requests.
+func (t *train) reply(response ping.Response) {
+	if len(t.arrivals) == 0 || response.Latency < t.lowest {
+		t.lowest = response.Latency
+	}
+	if len(t.arrivals) == 0 || response.Latency > t.highest {
+		t.highest = response.Latency
+	}
+	t.arrivals = append(t.arrivals, response.Request.TimeSent.Add(response.Latency))
+}
+
+// full returns true if all requests of the train received a reply.
+func (t *train) full() bool {
+	return len(t.arrivals) == t.size
+}
+
+// done returns true if all requests of the train received a reply, or if the train has timed out.
+func (t *train) done(timeout time.Duration) bool {
+	return t.full() || time.Since(t.sent) > timeout
+}
+
+// complete adds the statistics of a finished train.
+func (s *trainStatistics) complete(t *train) {
+	s.completed++
+	s.lost += t.size - len(t.arrivals)
+	if len(t.arrivals) < 2 {
+		return
+	}
+	s.spreads = append(s.spreads, t.highest-t.lowest)
+	// the packets leave back-to-back, so the time between two arrivals is determined by the slowest link on the path
+	slices.SortFunc(t.arrivals, func(a, b time.Time) int { return a.Compare(b) })
+	dispersion := t.arrivals[len(t.arrivals)-1].Sub(t.arrivals[0]) / time.Duration(len(t.arrivals)-1)
+	s.dispersions = append(s.dispersions, dispersion)
+}
@@ -0,0 +1,86 @@
+package pinger
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarget_Train(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		target := Target{Name: "localhost", Host: "127.0.0.1", Train: 4, Timeout: time.Second}
+		require.NoError(t, target.setTiming())
+		reply := func(seq ping.SequenceNumber, sent time.Time, latency time.Duration) {
+			target.markResponse(ping.Response{Latency: latency, Request: ping.Request{Seq: seq, TimeSent: sent}})
+		}
+
+		// first train: all replies arrive, 1ms apart
+		sent := time.Now()
+		target.markTrain([]ping.SequenceNumber{1, 2, 3, 4})
+		for seq := range ping.SequenceNumber(4) {
+			target.markRequest(seq + 1)
+		}
+		reply(1, sent, 10*time.Millisecond)
+		reply(2, sent, 11*time.Millisecond)
+		reply(3, sent, 12*time.Millisecond)
+		reply(4, sent, 13*time.Millisecond)
+
+		// second train: one reply is lost
+		time.Sleep(time.Second)
+		sent = time.Now()
+		target.markTrain([]ping.SequenceNumber{5, 6, 7, 8})
+		for seq := range ping.SequenceNumber(4) {
+			target.markRequest(seq + 5)
+		}
+		reply(5, sent, 10*time.Millisecond)
+		reply(6, sent, 20*time.Millisecond)
+		reply(8, sent, 40*time.Millisecond)
+
+		// the second train isn't complete until it times out
+		statistics := target.statistics()
+		assert.Equal(t, 4, statistics.TrainSize)
+		assert.Equal(t, 1, statistics.Trains)
+		assert.Zero(t, statistics.TrainLost)
+		assert.Equal(t, 3*time.Millisecond, statistics.TrainSpread)
+		assert.Equal(t, time.Millisecond, statistics.TrainDispersion)
+
+		time.Sleep(2 * time.Second)
+		statistics = target.statistics()
+		assert.Equal(t, 1, statistics.Trains)
+		assert.Equal(t, 1, statistics.TrainLost)
+		assert.Equal(t, 30*time.Millisecond, statistics.TrainSpread)
+		assert.Equal(t, 15*time.Millisecond, statistics.TrainDispersion)
+		assert.Empty(t, target.trains)
+
+		// counters are reset
+		statistics = target.statistics()
+		assert.Zero(t, statistics.Trains)
+		assert.Zero(t, statistics.TrainLost)
+	})
+}
+
+func TestPinger_Train(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		targets := Targets{
+			&Target{Name: "localhost", Host: "127.0.0.1", Train: 3},
+		}
+		var s recordingSocket
+		p := New(targets, func(SocketConfig) (Socket, error) { return &s, nil }, slog.New(slog.DiscardHandler), WithMaxPPS(3))
+		ctx, cancel := context.WithCancel(t.Context())
+		start := time.Now()
+		go p.Run(ctx)
+		time.Sleep(1500 * time.Millisecond)
+		cancel()
+		synctest.Wait()
+
+		// the packets of a train are sent back-to-back, even with a pps cap
+		assert.Equal(t, []time.Duration{0, 0, 0, time.Second, time.Second, time.Second}, s.offsets(start))
+		assert.Len(t, targets[0].trains, 6)
+	})
+}
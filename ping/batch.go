@@ -0,0 +1,199 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// A Packet is an echo request sent by SendBatch.
+type Packet struct {
+	Target net.IP
+	Data   []byte
+	// Timeout is how long the Socket waits for a response before it reports a ResponseTimeout.
+	// If zero, the Socket's Timeout is used.
+	Timeout time.Duration
+	Seq     SequenceNumber
+	TTL     uint8
+}
+
+// SendBatch sends the packets with as few system calls as possible (sendmmsg on Linux). This is considerably cheaper
+// than calling Send for each packet when pinging many targets. It returns the number of packets sent: if an error
+// occurs, the remaining packets are not sent.
+//
+// Each packet has its own TTL, so packets with different TTLs are sent together. Consecutive packets with the same IP
+// version are sent together and have the same Request.TimeSent.
+func (s *Socket) SendBatch(packets []Packet) (int, error) {
+	return s.sendPackets(s.handle, packets)
+}
+
+// SendBatch works like Socket.SendBatch, but the responses are only delivered to the Handle.
+func (h *Handle) SendBatch(packets []Packet) (int, error) {
+	return h.socket.sendPackets(h, packets)
+}
+
+// sendPackets sends the packets in runs of packets with the same IP version.
+func (s *Socket) sendPackets(h *Handle, packets []Packet) (int, error) {
+	var sent int
+	for len(packets) > 0 {
+		socket, err := s.conn(packets[0].Target)
+		if err != nil {
+			return sent, err
+		}
+		run := 1
+		for run < len(packets) {
+			if c, _ := s.conn(packets[run].Target); c != socket {
+				break
+			}
+			run++
+		}
+		n, err := s.sendRun(h, socket, packets[:run])
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		packets = packets[run:]
+	}
+	return sent, nil
+}
+
+// sendRun sends packets with the same IP version. The TTL is set per packet, so runs can be sent concurrently.
+func (s *Socket) sendRun(h *Handle, socket *conn, packets []Packet) (int, error) {
+	out := make([]outgoingPacket, 0, len(packets))
+	buffers := make([]*[]byte, 0, len(packets))
+	defer func() {
+		for _, b := range buffers {
+			putBuffer(b)
+		}
+	}()
+	requestType, v6 := byte(ipv4.ICMPTypeEcho), false
+	if socket.IPv6PacketConn() != nil {
+		requestType, v6 = byte(ipv6.ICMPTypeEchoRequest), true
+	}
+
+	for _, p := range packets {
+		s.logger.Debug("sending packet", "addr", p.Target, "ttl", p.TTL)
+	}
+
+	// mark the outstanding requests before sending them, so a fast reply is always matched to its request
+	s.lock.Lock()
+	wireSeqs := make([]SequenceNumber, 0, len(packets))
+	for _, p := range packets {
+		// each Handle has its own sequence space, so use a sequence number that isn't used by any other request to the target
+		wireSeq, err := s.nextSeq(p.Target)
+		if err != nil {
+			s.lock.Unlock()
+			return 0, err
+		}
+		wireSeqs = append(wireSeqs, wireSeq)
+	}
+	// the packets of a run are written together, so they share the time sent. take it once the sequence
+	// numbers are allocated, so the latency only includes the time spent creating the packets, not the time spent
+	// waiting for the lock.
+	timeSent := time.Now()
+	keys := make([]requestKey, 0, len(packets))
+	var wakeup bool
+	for i, p := range packets {
+		b := getBuffer()
+		buffers = append(buffers, b)
+		*b = appendEcho(*b, requestType, v6, int(s.id), wireSeqs[i], payload{nonce: s.nonce, timeSent: timeSent}, p.Data)
+		out = append(out, outgoingPacket{b: *b, addr: socket.addr(p.Target), ttl: p.TTL})
+
+		key := s.requestKey(p.Target, int(s.id), wireSeqs[i])
+		keys = append(keys, key)
+		if s.track(key, request{
+			handle: h,
+			Request: Request{
+				Target:   p.Target,
+				TTL:      p.TTL,
+				Seq:      p.Seq,
+				TimeSent: timeSent,
+				Timeout:  p.Timeout,
+			},
+		}) {
+			wakeup = true
+		}
+	}
+	s.lock.Unlock()
+	if wakeup {
+		// the requests expire before the next scheduled timeout check
+		select {
+		case s.wakeup <- struct{}{}:
+		default:
+		}
+	}
+
+	n, err := socket.writeBatch(out)
+	if err != nil {
+		// these requests won't receive a response
+		s.cancel(keys[n:])
+		if errors.Is(err, syscall.EMSGSIZE) {
+			err = fmt.Errorf("%w: %w", ErrPacketTooBig, err)
+		}
+	}
+	return n, err
+}
+
+// cancel removes requests that weren't sent.
+func (s *Socket) cancel(keys []requestKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, key := range keys {
+		delete(s.outstandingRequests, key)
+	}
+}
+
+// conn returns the icmp socket used to send packets to the target.
+func (s *Socket) conn(target net.IP) (*conn, error) {
+	var socket *conn
+	switch {
+	case target.To4() != nil:
+		socket = s.v4
+	case target.To16() != nil:
+		socket = s.v6
+	default:
+		return nil, fmt.Errorf("unable to determine IP version for %q", target)
+	}
+	if socket == nil {
+		return nil, fmt.Errorf("no icmp socket for %s: IP version not enabled", target)
+	}
+	return socket, nil
+}
+
+// newBatch returns the messages to receive a batch of packets.
+func newBatch(size int) []ipv4.Message {
+	const maxOOBSize = 128
+	msgs := make([]ipv4.Message, size)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, maxReceiveSize)}
+		msgs[i].OOB = make([]byte, maxOOBSize)
+	}
+	return msgs
+}
+
+// readBatch reads up to len(msgs) packets from the socket with one system call (recvmmsg on Linux) and parses them.
+// f is called for each packet.
+func (s *Socket) readBatch(socket *conn, msgs []ipv4.Message, f func(response, error)) {
+	if err := socket.SetReadDeadline(time.Now().Add(s.Timeout)); err != nil {
+		f(response{}, fmt.Errorf("failed to set deadline: %w", err))
+		return
+	}
+	n, err := socket.readBatch(msgs)
+	if err != nil {
+		f(response{}, fmt.Errorf("read: %w", err))
+		return
+	}
+	protocol := socket.protocol()
+	for _, msg := range msgs[:n] {
+		received, source := receiveTime(msg.OOB[:msg.NN])
+		resp, err := s.parsePacket(protocol, msg.Buffers[0][:msg.N], ip(msg.Addr), received)
+		resp.TimestampSource = source
+		resp.TTL = socket.receivedTTL(msg.OOB[:msg.NN])
+		f(resp, err)
+	}
+}
@@ -0,0 +1,152 @@
+package ping_test
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocket_SendBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		mode ping.SocketMode
+	}{
+		{"raw", ping.ModeRaw},
+		{"datagram", ping.ModeDatagram},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ping.New(ping.WithIPv4(), ping.WithMode(tt.mode), ping.WithoutCheckID(), ping.WithBatchSize(16), ping.WithLogger(slog.New(slog.DiscardHandler)))
+			if errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrNotExist) {
+				t.Skip("IPv4 not supported")
+			}
+			require.NoError(t, err)
+
+			ctx := t.Context()
+			go s.Serve(ctx)
+
+			// more packets than the batch size, with different TTLs
+			const targetCount = 50
+			packets := make([]ping.Packet, 0, targetCount)
+			for i := range targetCount {
+				packets = append(packets, ping.Packet{
+					Target: net.IPv4(127, 0, 0, byte(i+1)),
+					Data:   []byte("payload"),
+					Seq:    ping.SequenceNumber(i),
+					TTL:    uint8(64 + i/25),
+				})
+			}
+			n, err := s.SendBatch(packets)
+			require.NoError(t, err)
+			assert.Equal(t, targetCount, n)
+
+			received := make(map[string]ping.SequenceNumber)
+			timesSent := make(map[time.Time]struct{})
+			for range targetCount {
+				resp, err := s.Read(ctx)
+				require.NoError(t, err)
+				require.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+				require.True(t, resp.From.Equal(resp.Request.Target), "from: %s, target: %s", resp.From, resp.Request.Target)
+				received[resp.From.String()] = resp.Request.Seq
+				timesSent[resp.Request.TimeSent] = struct{}{}
+			}
+			require.Len(t, received, targetCount)
+			// the packets are sent together, so they share the time sent
+			assert.Len(t, timesSent, 1)
+			for _, p := range packets {
+				assert.Equal(t, p.Seq, received[p.Target.String()])
+			}
+		})
+	}
+}
+
+func TestSocket_SendBatch_Errors(t *testing.T) {
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	// IPv6 is not enabled: the packets before the IPv6 target are sent
+	n, err := s.SendBatch([]ping.Packet{
+		{Target: net.ParseIP("127.0.0.1")},
+		{Target: net.ParseIP("::1")},
+		{Target: net.ParseIP("127.0.0.1")},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = ping.New(ping.WithBatchSize(0))
+	assert.Error(t, err)
+}
+
+// BenchmarkSocket compares sending and receiving packets one at a time with batched sends and receives.
+// Each iteration pings 64 targets and waits for all replies.
+func BenchmarkSocket(b *testing.B) {
+	const targetCount = 64
+	b.Run("single", func(b *testing.B) {
+		s := newBenchmarkSocket(b)
+		packets := benchmarkPackets(targetCount)
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, p := range packets {
+				if err := s.Send(p.Target, p.Seq, p.TTL, p.Data); err != nil {
+					b.Fatal(err)
+				}
+			}
+			readResponses(b, s, targetCount)
+		}
+		b.ReportMetric(float64(b.N*targetCount)/b.Elapsed().Seconds(), "packets/s")
+	})
+	b.Run("batch", func(b *testing.B) {
+		s := newBenchmarkSocket(b, ping.WithBatchSize(targetCount))
+		packets := benchmarkPackets(targetCount)
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := s.SendBatch(packets); err != nil {
+				b.Fatal(err)
+			}
+			readResponses(b, s, targetCount)
+		}
+		b.ReportMetric(float64(b.N*targetCount)/b.Elapsed().Seconds(), "packets/s")
+	})
+}
+
+func newBenchmarkSocket(b *testing.B, opts ...ping.SocketOption) *ping.Socket {
+	b.Helper()
+	// a short timeout & grace period, so sequence numbers can be reused
+	opts = append(opts, ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(),
+		ping.WithTimeout(time.Second), ping.WithGracePeriod(time.Second), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	s, err := ping.New(opts...)
+	if errors.Is(err, os.ErrPermission) {
+		b.Skip("IPv4 not supported")
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+	go s.Serve(b.Context())
+	return s
+}
+
+func benchmarkPackets(count int) []ping.Packet {
+	packets := make([]ping.Packet, count)
+	for i := range packets {
+		packets[i] = ping.Packet{Target: net.IPv4(127, 0, 0, byte(i+1)), Data: []byte("payload"), TTL: 64}
+	}
+	return packets
+}
+
+func readResponses(b *testing.B, s *ping.Socket, count int) {
+	for range count {
+		if _, err := s.Read(b.Context()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// SocketMode determines the type of icmp socket used by a Socket.
+type SocketMode int
+
+const (
+	// ModeDatagram uses unprivileged icmp datagram sockets ("udp4", "udp6").
+	// On Linux, this requires the process's group to be in net.ipv4.ping_group_range. The kernel replaces the ID of
+	// the echo requests, so the Socket doesn't check the ID of the replies and relies on the payload's nonce instead.
+	ModeDatagram SocketMode = iota
+	// ModeRaw uses privileged raw icmp sockets ("ip4:icmp", "ip6:ipv6-icmp"). This requires CAP_NET_RAW.
+	ModeRaw
+	// ModeAuto uses raw sockets if possible and falls back to datagram sockets otherwise.
+	ModeAuto
+)
+
+func (m SocketMode) String() string {
+	switch m {
+	case ModeDatagram:
+		return "datagram"
+	case ModeRaw:
+		return "raw"
+	case ModeAuto:
+		return "auto"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSocketMode returns the SocketMode for the provided name ("datagram", "raw" or "auto").
+func ParseSocketMode(name string) (SocketMode, error) {
+	for _, mode := range []SocketMode{ModeDatagram, ModeRaw, ModeAuto} {
+		if name == mode.String() {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid socket mode: %q", name)
+}
+
+// dscpNames holds the DSCP values of the standard per-hop behaviours (RFC 2474, RFC 2597, RFC 3246, RFC 5865, RFC 8622).
+var dscpNames = map[string]uint8{
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+	"EF": 46, "VOICE-ADMIT": 44, "LE": 1, "BE": 0,
+}
+
+// ParseDSCP returns the DSCP value for the provided name (e.g. "EF", "AF41" or "CS1") or decimal number (0-63).
+func ParseDSCP(name string) (uint8, error) {
+	if dscp, ok := dscpNames[strings.ToUpper(name)]; ok {
+		return dscp, nil
+	}
+	// always decimal: "010" is 10, not 8
+	dscp, err := strconv.ParseUint(name, 10, 6)
+	if err != nil {
+		return 0, fmt.Errorf("invalid dscp: %q", name)
+	}
+	return uint8(dscp), nil
+}
+
+// TimestampSource identifies how the time a response was received was determined.
+type TimestampSource int
+
+const (
+	// TimestampUserspace means the time was taken after reading the packet from the socket.
+	TimestampUserspace TimestampSource = iota
+	// TimestampKernel means the time was taken by the kernel's network stack when the packet was received.
+	TimestampKernel
+)
+
+func (t TimestampSource) String() string {
+	switch t {
+	case TimestampUserspace:
+		return "userspace"
+	case TimestampKernel:
+		return "kernel"
+	default:
+		return "unknown"
+	}
+}
+
+// conn is an icmp socket for one IP version.
+type conn struct {
+	net.PacketConn
+	p4 *ipv4.PacketConn
+	p6 *ipv6.PacketConn
+	// lock serialises sends on platforms that set the TTL on the socket, rather than per packet.
+	lock sync.Mutex
+	raw  bool
+}
+
+// outgoingPacket is a packet sent by writeBatch.
+type outgoingPacket struct {
+	addr net.Addr
+	b    []byte
+	// ttl is the TTL (IPv4) or hop limit (IPv6) of the packet. Zero uses the socket's default.
+	ttl uint8
+}
+
+// IPv4PacketConn returns the ipv4.PacketConn of the socket. It returns nil if the socket is not an IPv4 socket.
+func (c *conn) IPv4PacketConn() *ipv4.PacketConn {
+	return c.p4
+}
+
+// IPv6PacketConn returns the ipv6.PacketConn of the socket. It returns nil if the socket is not an IPv6 socket.
+func (c *conn) IPv6PacketConn() *ipv6.PacketConn {
+	return c.p6
+}
+
+// listenConfig holds the network & address to listen on for each SocketMode.
+type listenConfig struct {
+	transport string
+	raw       string
+	datagram  string
+	address   string
+	ipv6      bool
+}
+
+var (
+	listenIPv4 = listenConfig{transport: "IPv4", raw: "ip4:icmp", datagram: "udp4", address: "0.0.0.0"}
+	listenIPv6 = listenConfig{transport: "IPv6", raw: "ip6:ipv6-icmp", datagram: "udp6", address: "::", ipv6: true}
+)
+
+// listen opens an icmp socket in the Socket's mode. In ModeAuto, it tries to open a raw socket first.
+func (s *Socket) listen(cfg listenConfig) (*conn, error) {
+	logger := s.logger.With("transport", cfg.transport)
+	if s.source != nil {
+		cfg.address = s.source.String()
+	}
+	var c *conn
+	open := func() (err error) {
+		c, err = s.open(cfg, logger)
+		return err
+	}
+	var err error
+	if s.netns != "" {
+		logger = logger.With("netns", s.netns)
+		err = inNetNS(netnsPath(s.netns), open)
+	} else {
+		err = open()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.raw {
+		// the kernel replaces the ID of our echo requests: match the replies by their nonce instead
+		s.checkID = false
+	}
+	if s.iface != "" {
+		if err = c.bindToDevice(s.iface); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("bind to interface %s: %w", s.iface, err)
+		}
+	}
+	if s.mark != 0 {
+		if err = c.setMark(s.mark); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("set mark %d: %w", s.mark, err)
+		}
+	}
+	if s.tos != 0 {
+		if err = c.setTOS(s.tos); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("set tos %#x: %w", s.tos, err)
+		}
+	}
+	if s.dontFragment {
+		if err = c.setDontFragment(); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("set don't fragment: %w", err)
+		}
+	}
+	if s.kernelTimestamps {
+		if err = c.enableTimestamps(); err != nil {
+			logger.Info("kernel timestamps not supported. using userspace timestamps", "err", err)
+		} else {
+			logger.Info("kernel timestamps enabled")
+		}
+	}
+	if err = c.enableReceivedTTL(); err != nil {
+		logger.Info("received TTL not supported", "err", err)
+	}
+	return c, nil
+}
+
+func (s *Socket) open(cfg listenConfig, logger *slog.Logger) (*conn, error) {
+	if s.mode == ModeRaw || s.mode == ModeAuto {
+		c, err := openConn(cfg, true)
+		if err == nil {
+			logger.Info("icmp socket opened", "mode", ModeRaw)
+			setFilter(c, logger)
+			return c, nil
+		}
+		if s.mode == ModeRaw {
+			return nil, err
+		}
+		logger.Info("failed to open raw socket. falling back to datagram socket", "err", err)
+	}
+	c, err := openConn(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("icmp socket opened", "mode", ModeDatagram)
+	return c, nil
+}
+
+// netnsPath returns the path of the network namespace file for the provided namespace name or path.
+func netnsPath(name string) string {
+	if strings.ContainsRune(name, '/') {
+		return name
+	}
+	return filepath.Join("/var/run/netns", name)
+}
+
+// setFilter limits the icmp messages received by a raw socket to those we can process.
+// Not all platforms support this, so failures are only logged.
+func setFilter(c *conn, logger *slog.Logger) {
+	var err error
+	if p := c.IPv4PacketConn(); p != nil {
+		var f ipv4.ICMPFilter
+		f.SetAll(true)
+		for _, t := range []ipv4.ICMPType{ipv4.ICMPTypeEchoReply, ipv4.ICMPTypeDestinationUnreachable, ipv4.ICMPTypeTimeExceeded, ipv4.ICMPTypeParameterProblem} {
+			f.Accept(t)
+		}
+		err = p.SetICMPFilter(&f)
+	}
+	if p := c.IPv6PacketConn(); p != nil {
+		var f ipv6.ICMPFilter
+		f.SetAll(true)
+		for _, t := range []ipv6.ICMPType{ipv6.ICMPTypeEchoReply, ipv6.ICMPTypeDestinationUnreachable, ipv6.ICMPTypePacketTooBig, ipv6.ICMPTypeTimeExceeded, ipv6.ICMPTypeParameterProblem} {
+			f.Accept(t)
+		}
+		err = p.SetICMPFilter(&f)
+	}
+	if err != nil {
+		logger.Debug("failed to set icmp filter", "err", err)
+	}
+}
+
+// setTOS sets the type of service (IPv4) or traffic class (IPv6) of the packets sent by the socket.
+func (c *conn) setTOS(tos uint8) error {
+	if p := c.IPv4PacketConn(); p != nil {
+		return p.SetTOS(int(tos))
+	}
+	return c.IPv6PacketConn().SetTrafficClass(int(tos))
+}
+
+// enableReceivedTTL asks the kernel to report the TTL (IPv4) or hop limit (IPv6) of each received packet.
+func (c *conn) enableReceivedTTL() error {
+	if p := c.IPv4PacketConn(); p != nil {
+		return p.SetControlMessage(ipv4.FlagTTL, true)
+	}
+	return c.IPv6PacketConn().SetControlMessage(ipv6.FlagHopLimit, true)
+}
+
+// receivedTTL returns the TTL (IPv4) or hop limit (IPv6) of a received packet, as reported in its control messages.
+// It returns zero if the control messages don't contain it.
+func (c *conn) receivedTTL(oob []byte) uint8 {
+	if c.IPv4PacketConn() != nil {
+		var cm ipv4.ControlMessage
+		if err := cm.Parse(oob); err != nil {
+			return 0
+		}
+		return uint8(cm.TTL)
+	}
+	var cm ipv6.ControlMessage
+	if err := cm.Parse(oob); err != nil {
+		return 0
+	}
+	return uint8(cm.HopLimit)
+}
+
+// addr returns the address to send a packet to the provided IP address.
+func (c *conn) addr(ip net.IP) net.Addr {
+	if c.raw {
+		return &net.IPAddr{IP: ip}
+	}
+	return &net.UDPAddr{IP: ip}
+}
+
+// readMsg reads a packet from the socket. If the platform supports it, it also returns the packet's control messages.
+func (c *conn) readMsg(b, oob []byte) (n, oobn int, from net.IP, err error) {
+	switch pc := c.PacketConn.(type) {
+	case *net.UDPConn:
+		var addr *net.UDPAddr
+		n, oobn, _, addr, err = pc.ReadMsgUDP(b, oob)
+		if addr != nil {
+			from = addr.IP
+		}
+	case *net.IPConn:
+		var addr *net.IPAddr
+		n, oobn, _, addr, err = pc.ReadMsgIP(b, oob)
+		if addr != nil {
+			from = addr.IP
+		}
+		// unlike ReadFrom, ReadMsgIP doesn't remove the IPv4 header
+		if err == nil && c.p4 != nil {
+			n, err = stripIPv4Header(b, n)
+		}
+	default:
+		var addr net.Addr
+		n, addr, err = c.ReadFrom(b)
+		from = ip(addr)
+	}
+	return n, oobn, from, err
+}
+
+// stripIPv4Header removes the IPv4 header from a packet received on a raw socket. It returns the length of the payload.
+func stripIPv4Header(b []byte, n int) (int, error) {
+	if n < ipv4.HeaderLen {
+		return 0, errors.New("packet too short")
+	}
+	hlen := int(b[0]&0x0f) << 2
+	if hlen < ipv4.HeaderLen || hlen > n {
+		return 0, fmt.Errorf("invalid IPv4 header length: %d", hlen)
+	}
+	copy(b, b[hlen:n])
+	return n - hlen, nil
+}
+
+// ip returns the IP address of the sender of a received packet.
+func ip(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	default:
+		return nil
+	}
+}
+
+// protocol returns the icmp protocol number of the socket's IP version.
+func (c *conn) protocol() int {
+	if c.IPv6PacketConn() != nil {
+		return 58
+	}
+	return 1
+}
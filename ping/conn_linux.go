@@ -0,0 +1,279 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// openConn opens an icmp socket. Raw sockets are opened with net.ListenPacket. For datagram sockets, net.ListenPacket
+// would create a UDP socket, so we create the socket ourselves.
+func openConn(cfg listenConfig, raw bool) (*conn, error) {
+	var c net.PacketConn
+	var err error
+	if raw {
+		c, err = net.ListenPacket(cfg.raw, cfg.address)
+	} else {
+		c, err = listenDatagram(cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cn := conn{PacketConn: c, raw: raw}
+	if cfg.ipv6 {
+		cn.p6 = ipv6.NewPacketConn(c)
+	} else {
+		cn.p4 = ipv4.NewPacketConn(c)
+	}
+	return &cn, nil
+}
+
+// inNetNS runs f in the network namespace at the provided path. Sockets created by f belong to that namespace.
+//
+// Network namespaces are per thread, so f runs on a dedicated, locked OS thread. If we fail to move the thread back
+// to its original namespace, the thread remains locked and is terminated when the goroutine exits.
+func inNetNS(path string, f func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		target, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- fmt.Errorf("netns %s: %w", path, os.NewSyscallError("open", err))
+			return
+		}
+		defer func() { _ = unix.Close(target) }()
+		current, err := unix.Open("/proc/thread-self/ns/net", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- fmt.Errorf("current netns: %w", os.NewSyscallError("open", err))
+			return
+		}
+		defer func() { _ = unix.Close(current) }()
+		if err = unix.Setns(target, unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errCh <- fmt.Errorf("netns %s: %w", path, os.NewSyscallError("setns", err))
+			return
+		}
+		ferr := f()
+		if err = unix.Setns(current, unix.CLONE_NEWNET); err != nil {
+			errCh <- errors.Join(ferr, fmt.Errorf("restore netns: %w", os.NewSyscallError("setns", err)))
+			return
+		}
+		runtime.UnlockOSThread()
+		errCh <- ferr
+	}()
+	return <-errCh
+}
+
+// listenDatagram opens an unprivileged icmp datagram socket.
+func listenDatagram(cfg listenConfig) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	if cfg.ipv6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+	sa, err := sockaddr(cfg.address, cfg.ipv6)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err = syscall.Bind(fd, sa); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer func() { _ = f.Close() }()
+	return net.FilePacketConn(f)
+}
+
+// sockaddr returns the syscall.Sockaddr for the provided address.
+func sockaddr(address string, v6 bool) (syscall.Sockaddr, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, &net.AddrError{Err: "invalid address", Addr: address}
+	}
+	if v6 {
+		var sa syscall.SockaddrInet6
+		copy(sa.Addr[:], ip.To16())
+		return &sa, nil
+	}
+	var sa syscall.SockaddrInet4
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, &net.AddrError{Err: "not an IPv4 address", Addr: address}
+	}
+	copy(sa.Addr[:], ip4)
+	return &sa, nil
+}
+
+// receivesErrors returns true if icmp errors for the packets sent by the socket are received as regular packets.
+// This is only the case for raw sockets: datagram sockets only see them on the socket's error queue (IP_RECVERR).
+func (c *conn) receivesErrors() bool {
+	return c.raw
+}
+
+// control runs f on the socket's file descriptor.
+func (c *conn) control(f func(fd int) error) error {
+	sc, ok := c.PacketConn.(syscall.Conn)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err = rc.Control(func(fd uintptr) { ferr = f(int(fd)) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// bindToDevice only sends and receives packets on the provided network interface.
+func (c *conn) bindToDevice(name string) error {
+	return c.control(func(fd int) error {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, name))
+	})
+}
+
+// setMark sets the firewall mark of the packets sent by the socket.
+func (c *conn) setMark(mark uint32) error {
+	return c.control(func(fd int) error {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, int(mark)))
+	})
+}
+
+// setDontFragment sets the don't fragment flag on the packets sent by the socket. In probe mode, the kernel also
+// ignores the path MTU it learned from earlier "packet too big" messages, so we can probe the path MTU ourselves.
+// Packets larger than the MTU of the outgoing interface fail with EMSGSIZE.
+func (c *conn) setDontFragment() error {
+	return c.control(func(fd int) error {
+		if c.p4 != nil {
+			return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE))
+		}
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_DONTFRAG, 1))
+	})
+}
+
+// enableTimestamps asks the kernel to add a receive timestamp to each packet. We prefer SO_TIMESTAMPING and fall back
+// to SO_TIMESTAMPNS.
+//
+// We only ask for software timestamps: hardware timestamps are taken by the network interface's clock, which isn't
+// synchronized with the system clock (e.g. it runs on TAI with PTP), so they can't be compared with the time sent.
+func (c *conn) enableTimestamps() error {
+	return c.control(func(fd int) error {
+		const flags = unix.SOF_TIMESTAMPING_SOFTWARE | unix.SOF_TIMESTAMPING_RX_SOFTWARE
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags); err == nil {
+			return nil
+		}
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1))
+	})
+}
+
+// receiveTime returns the time the packet was received, as reported by the kernel in the packet's control messages.
+// If the control messages don't contain a timestamp, it returns the current time.
+func receiveTime(oob []byte) (time.Time, TimestampSource) {
+	msgs, _ := unix.ParseSocketControlMessage(oob)
+	for _, msg := range msgs {
+		if msg.Header.Level != unix.SOL_SOCKET {
+			continue
+		}
+		switch msg.Header.Type {
+		case unix.SCM_TIMESTAMPING:
+			// three timestamps: software, deprecated, raw hardware. we only asked for the software timestamp.
+			if len(msg.Data) < 3*int(unsafe.Sizeof(unix.Timespec{})) {
+				continue
+			}
+			ts := (*[3]unix.Timespec)(unsafe.Pointer(&msg.Data[0]))
+			if ts[0].Nano() != 0 {
+				return time.Unix(ts[0].Unix()), TimestampKernel
+			}
+		case unix.SCM_TIMESTAMPNS:
+			if len(msg.Data) < int(unsafe.Sizeof(unix.Timespec{})) {
+				continue
+			}
+			ts := (*unix.Timespec)(unsafe.Pointer(&msg.Data[0]))
+			return time.Unix(ts.Unix()), TimestampKernel
+		}
+	}
+	return time.Now(), TimestampUserspace
+}
+
+// readBatch reads up to len(msgs) packets with one system call (recvmmsg). It returns the number of packets read.
+func (c *conn) readBatch(msgs []ipv4.Message) (int, error) {
+	var n int
+	var err error
+	if c.p4 != nil {
+		n, err = c.p4.ReadBatch(msgs, 0)
+	} else {
+		n, err = c.p6.ReadBatch(msgs, 0)
+	}
+	if err != nil || !c.raw || c.p4 == nil {
+		return n, err
+	}
+	// like ReadMsgIP, ReadBatch doesn't remove the IPv4 header. a packet with an invalid header fails to parse.
+	for i := range msgs[:n] {
+		msgs[i].N, _ = stripIPv4Header(msgs[i].Buffers[0], msgs[i].N)
+	}
+	return n, nil
+}
+
+// writeBatch sends the packets with as few system calls as possible (sendmmsg). It returns the number of packets sent.
+// The TTL of each packet is passed as a control message, so the socket's options aren't changed and concurrent sends
+// with different TTLs don't interfere with each other.
+func (c *conn) writeBatch(packets []outgoingPacket) (int, error) {
+	msgs := make([]ipv4.Message, len(packets))
+	for i, p := range packets {
+		msgs[i] = ipv4.Message{Buffers: [][]byte{p.b}, OOB: c.ttlControl(p.ttl), Addr: p.addr}
+	}
+	var sent int
+	for sent < len(msgs) {
+		var n int
+		var err error
+		if c.p4 != nil {
+			n, err = c.p4.WriteBatch(msgs[sent:], 0)
+		} else {
+			n, err = c.p6.WriteBatch(msgs[sent:], 0)
+		}
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// ttlControl returns the control message that sets the TTL (IP_TTL) or hop limit (IPV6_HOPLIMIT) of a packet.
+// Both take an int. It returns nil if ttl is zero, so the packet uses the socket's default.
+func (c *conn) ttlControl(ttl uint8) []byte {
+	if ttl == 0 {
+		return nil
+	}
+	level, typ := unix.IPPROTO_IP, unix.IP_TTL
+	if c.p6 != nil {
+		level, typ = unix.IPPROTO_IPV6, unix.IPV6_HOPLIMIT
+	}
+	const size = int(unsafe.Sizeof(int32(0)))
+	b := make([]byte, unix.CmsgSpace(size))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(unix.CmsgLen(size))
+	*(*int32)(unsafe.Pointer(&b[unix.CmsgLen(0)])) = int32(ttl)
+	return b
+}
@@ -0,0 +1,125 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+func TestReceiveTime(t *testing.T) {
+	// a SCM_TIMESTAMPING message with a software and a raw hardware timestamp, e.g. from a PHC running on TAI
+	const size = int(unsafe.Sizeof(unix.Timespec{}))
+	oob := make([]byte, unix.CmsgSpace(3*size))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.SOL_SOCKET
+	h.Type = unix.SCM_TIMESTAMPING
+	h.SetLen(unix.CmsgLen(3 * size))
+	ts := (*[3]unix.Timespec)(unsafe.Pointer(&oob[unix.CmsgLen(0)]))
+	ts[0] = unix.NsecToTimespec(time.Unix(1000, 0).UnixNano())
+	ts[2] = unix.NsecToTimespec(time.Unix(1037, 0).UnixNano())
+
+	// the hardware timestamp is ignored
+	received, source := receiveTime(oob)
+	assert.Equal(t, time.Unix(1000, 0), received)
+	assert.Equal(t, TimestampKernel, source)
+
+	_, source = receiveTime(nil)
+	assert.Equal(t, TimestampUserspace, source)
+}
+
+func TestConn_ttlControl(t *testing.T) {
+	tests := []struct {
+		name      string
+		conn      *conn
+		wantLevel int32
+		wantType  int32
+	}{
+		{"IPv4", &conn{p4: &ipv4.PacketConn{}}, unix.IPPROTO_IP, unix.IP_TTL},
+		{"IPv6", &conn{p6: &ipv6.PacketConn{}}, unix.IPPROTO_IPV6, unix.IPV6_HOPLIMIT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Nil(t, tt.conn.ttlControl(0))
+
+			msgs, err := unix.ParseSocketControlMessage(tt.conn.ttlControl(7))
+			require.NoError(t, err)
+			require.Len(t, msgs, 1)
+			assert.Equal(t, tt.wantLevel, msgs[0].Header.Level)
+			assert.Equal(t, tt.wantType, msgs[0].Header.Type)
+			require.Len(t, msgs[0].Data, 4)
+			assert.Equal(t, uint32(7), binary.NativeEndian.Uint32(msgs[0].Data))
+		})
+	}
+}
+
+func TestSocket_PerPacketTTL(t *testing.T) {
+	s, err := New(WithIPv4(), WithMode(ModeAuto), WithoutCheckID(), WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+	before, err := s.v4.IPv4PacketConn().TTL()
+	require.NoError(t, err)
+
+	// the TTL is sent with the packet: the socket's TTL doesn't change
+	require.NoError(t, s.Send(net.ParseIP("127.0.0.1"), 1, 7, []byte("payload")))
+	after, err := s.v4.IPv4PacketConn().TTL()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestSocket_ReceivedTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []SocketOption
+		target string
+	}{
+		{"IPv4", []SocketOption{WithIPv4()}, "127.0.0.1"},
+		{"IPv4 batch", []SocketOption{WithIPv4(), WithBatchSize(8)}, "127.0.0.1"},
+		{"IPv6", []SocketOption{WithIPv6()}, "::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(append(tt.opts, WithMode(ModeAuto), WithoutCheckID(), WithLogger(slog.New(slog.DiscardHandler)))...)
+			if errors.Is(err, os.ErrPermission) || errors.Is(err, unix.EAFNOSUPPORT) {
+				t.Skip("IP version not supported")
+			}
+			require.NoError(t, err)
+
+			ctx := t.Context()
+			go s.Serve(ctx)
+			require.NoError(t, s.Send(net.ParseIP(tt.target), 1, 64, []byte("payload")))
+			resp, err := s.Read(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, ResponseEchoReply, resp.ResponseType)
+			assert.NotZero(t, resp.TTL)
+		})
+	}
+}
+
+func TestConn_receivedTTL(t *testing.T) {
+	// a timestamp, followed by the TTL
+	oob := make([]byte, unix.CmsgSpace(16)+unix.CmsgSpace(4))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level, h.Type = unix.SOL_SOCKET, unix.SCM_TIMESTAMPNS
+	h.SetLen(unix.CmsgLen(16))
+	h = (*unix.Cmsghdr)(unsafe.Pointer(&oob[unix.CmsgSpace(16)]))
+	h.Level, h.Type = unix.IPPROTO_IP, unix.IP_TTL
+	h.SetLen(unix.CmsgLen(4))
+	binary.NativeEndian.PutUint32(oob[unix.CmsgSpace(16)+unix.CmsgLen(0):], 57)
+
+	c := conn{p4: &ipv4.PacketConn{}}
+	assert.Equal(t, uint8(57), c.receivedTTL(oob))
+	assert.Zero(t, c.receivedTTL(nil))
+}
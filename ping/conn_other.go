@@ -0,0 +1,97 @@
+//go:build !linux
+
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// openConn opens an icmp socket.
+func openConn(cfg listenConfig, raw bool) (*conn, error) {
+	network := cfg.datagram
+	if raw {
+		network = cfg.raw
+	}
+	c, err := icmp.ListenPacket(network, cfg.address)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{PacketConn: c, p4: c.IPv4PacketConn(), p6: c.IPv6PacketConn(), raw: raw}, nil
+}
+
+// receivesErrors returns true: only Linux withholds icmp errors from datagram sockets.
+func (c *conn) receivesErrors() bool {
+	return true
+}
+
+// inNetNS is only supported on Linux.
+func inNetNS(_ string, _ func() error) error {
+	return errors.ErrUnsupported
+}
+
+// bindToDevice is only supported on Linux.
+func (c *conn) bindToDevice(_ string) error {
+	return errors.ErrUnsupported
+}
+
+// setMark is only supported on Linux.
+func (c *conn) setMark(_ uint32) error {
+	return errors.ErrUnsupported
+}
+
+// setDontFragment is only supported on Linux.
+func (c *conn) setDontFragment() error {
+	return errors.ErrUnsupported
+}
+
+// enableTimestamps is only supported on Linux.
+func (c *conn) enableTimestamps() error {
+	return errors.ErrUnsupported
+}
+
+// receiveTime returns the current time: kernel timestamps are only supported on Linux.
+func receiveTime(_ []byte) (time.Time, TimestampSource) {
+	return time.Now(), TimestampUserspace
+}
+
+// readBatch reads one packet: batch reads are only supported on Linux.
+func (c *conn) readBatch(msgs []ipv4.Message) (int, error) {
+	n, oobn, from, err := c.readMsg(msgs[0].Buffers[0], msgs[0].OOB)
+	if err != nil {
+		return 0, err
+	}
+	msgs[0].N, msgs[0].NN, msgs[0].Addr = n, oobn, &net.IPAddr{IP: from}
+	return 1, nil
+}
+
+// writeBatch sends the packets one at a time: batch writes and per-packet TTLs are only supported on Linux.
+// The TTL is set on the socket instead, so packets are sent under the conn's lock.
+func (c *conn) writeBatch(packets []outgoingPacket) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, p := range packets {
+		if p.ttl != 0 {
+			if err := c.setTTL(p.ttl); err != nil {
+				return i, fmt.Errorf("icmp socket failed to set ttl: %w", err)
+			}
+		}
+		if _, err := c.WriteTo(p.b, p.addr); err != nil {
+			return i, err
+		}
+	}
+	return len(packets), nil
+}
+
+// setTTL sets the TTL (IPv4) or hop limit (IPv6) of the packets sent by the socket.
+func (c *conn) setTTL(ttl uint8) error {
+	if c.p4 != nil {
+		return c.p4.SetTTL(int(ttl))
+	}
+	return c.p6.SetHopLimit(int(ttl))
+}
@@ -0,0 +1,24 @@
+package ping
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocket_WithTOS(t *testing.T) {
+	const tos = 46 << 2 // EF
+	s, err := New(WithIPv4(), WithMode(ModeAuto), WithTOS(tos), WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	got, err := s.v4.IPv4PacketConn().TOS()
+	require.NoError(t, err)
+	assert.Equal(t, tos, got)
+}
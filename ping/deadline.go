@@ -0,0 +1,38 @@
+package ping
+
+import (
+	"container/heap"
+	"time"
+)
+
+// A deadline is the time an outstanding request times out or, if purge is set, the time the Socket forgets a request
+// that timed out or received a reply.
+type deadline struct {
+	at    time.Time
+	key   requestKey
+	purge bool
+}
+
+// deadlines is a min-heap of deadlines, so the Socket finds the requests that time out without scanning all requests.
+//
+// Deadlines aren't removed when a request receives a reply or is canceled: timeout ignores deadlines that no longer
+// match a request.
+type deadlines []deadline
+
+var _ heap.Interface = (*deadlines)(nil)
+
+func (d deadlines) Len() int           { return len(d) }
+func (d deadlines) Less(i, j int) bool { return d[i].at.Before(d[j].at) }
+func (d deadlines) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func (d *deadlines) Push(x any) {
+	*d = append(*d, x.(deadline))
+}
+
+func (d *deadlines) Pop() any {
+	old := *d
+	n := len(old)
+	x := old[n-1]
+	*d = old[:n-1]
+	return x
+}
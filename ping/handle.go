@@ -0,0 +1,42 @@
+package ping
+
+import (
+	"net"
+	"time"
+)
+
+// A Handle sends echo requests on behalf of one consumer of a Socket (e.g. a pinger, a traceroute or an ad-hoc ping)
+// and only receives the responses to its own requests.
+//
+// Each Handle has its own sequence space: the Socket assigns a unique sequence number to each request on the wire,
+// so Handles may use the same sequence numbers for the same target without interfering with each other.
+// Responses report the sequence number used by the Handle.
+//
+// Like a Subscription, a Handle buffers its responses and drops new responses if its buffer is full (see Dropped).
+type Handle struct {
+	*mailbox
+	socket *Socket
+}
+
+// Register creates a new Handle, buffering up to size responses. Call Close when the Handle is no longer needed.
+func (s *Socket) Register(size int) *Handle {
+	return &Handle{
+		mailbox: newMailbox(size),
+		socket:  s,
+	}
+}
+
+// Send works like Socket.Send, but the response is only delivered to the Handle.
+func (h *Handle) Send(target net.IP, seq SequenceNumber, ttl uint8, data []byte) error {
+	return h.SendWithTimeout(target, seq, ttl, data, h.socket.Timeout)
+}
+
+// SendWithTimeout works like Socket.SendWithTimeout, but the response is only delivered to the Handle.
+func (h *Handle) SendWithTimeout(target net.IP, seq SequenceNumber, ttl uint8, data []byte, timeout time.Duration) error {
+	return h.socket.sendWithTimeout(h, target, seq, ttl, data, timeout)
+}
+
+// Close closes the Handle. Responses to its outstanding requests are discarded.
+func (h *Handle) Close() {
+	h.close()
+}
@@ -0,0 +1,56 @@
+package ping_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle(t *testing.T) {
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+
+	// all handles use the same sequence numbers for the same target
+	const packetCount = 5
+	target := net.ParseIP("127.0.0.1")
+	handles := []*ping.Handle{s.Register(packetCount), s.Register(packetCount)}
+	for _, h := range handles {
+		for seq := range ping.SequenceNumber(packetCount) {
+			require.NoError(t, h.Send(target, seq, 64, []byte("payload")))
+		}
+	}
+
+	// each handle receives the replies to its own requests
+	for _, h := range handles {
+		for seq := range ping.SequenceNumber(packetCount) {
+			resp, err := h.Read(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+			assert.Equal(t, seq, resp.Request.Seq)
+		}
+		assert.Zero(t, h.Dropped())
+		h.Close()
+		_, err = h.Read(ctx)
+		assert.ErrorIs(t, err, ping.ErrSubscriptionClosed)
+	}
+
+	// the Socket's own handle doesn't receive them
+	ctx2, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = s.Read(ctx2)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,116 @@
+package ping_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// netnsChildEnv is set when TestSocket_NetNS runs in its child process.
+const netnsChildEnv = "PING_TEST_NETNS_CHILD"
+
+// TestSocket_NetNS runs the test in a child process with its own user & network namespace. In its user namespace,
+// the child has the capabilities to create network namespaces and raw sockets, so the test doesn't need any privileges.
+func TestSocket_NetNS(t *testing.T) {
+	if os.Getenv(netnsChildEnv) != "" {
+		testSocketNetNS(t)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSocket_NetNS$", "-test.v")
+	cmd.Env = append(os.Environ(), netnsChildEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Skipf("unprivileged user namespaces not supported: %v", err)
+	}
+	require.NoError(t, err, string(out))
+	if bytes.Contains(out, []byte("--- SKIP")) {
+		t.Skip(string(out))
+	}
+}
+
+func testSocketNetNS(t *testing.T) {
+	netns, err := newNetNS(t)
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("creating a network namespace requires CAP_SYS_ADMIN")
+	}
+	require.NoError(t, err)
+
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithNetNS(netns), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+	require.NoError(t, s.Send(net.ParseIP("127.0.0.1"), 1, 64, []byte("payload")))
+	resp, err := s.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+
+	_, err = ping.New(ping.WithIPv4(), ping.WithNetNS("not-a-namespace"), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	assert.Error(t, err)
+}
+
+// newNetNS creates a network namespace with the loopback interface up and returns its path.
+func newNetNS(t *testing.T) (string, error) {
+	t.Helper()
+	type result struct {
+		path string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		// never unlock the thread: it's terminated when the goroutine exits, taking the namespace with it.
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			ch <- result{err: os.NewSyscallError("unshare", err)}
+			return
+		}
+		// keep a reference to the namespace, so it survives the thread
+		fd, err := unix.Open("/proc/thread-self/ns/net", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			ch <- result{err: os.NewSyscallError("open", err)}
+			return
+		}
+		t.Cleanup(func() { _ = unix.Close(fd) })
+		ch <- result{path: fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), fd), err: setLoopbackUp()}
+	}()
+	r := <-ch
+	return r.path, r.err
+}
+
+func setLoopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	defer func() { _ = unix.Close(fd) }()
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err = unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return os.NewSyscallError("ioctl", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return os.NewSyscallError("ioctl", unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr))
+}
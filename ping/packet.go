@@ -0,0 +1,64 @@
+package ping
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	// maxPacketSize is the initial size of the buffers used to send packets. Larger packets grow the buffer.
+	maxPacketSize = 1500
+	// maxReceiveSize is the size of the buffers used to receive packets. This holds the largest icmp message that fits
+	// in an IP packet, so replies to large echo requests aren't truncated.
+	maxReceiveSize = 1 << 16
+)
+
+// packetBuffers holds the buffers used to send and receive packets, so we don't allocate a buffer for every packet.
+var packetBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, maxPacketSize)
+		return &b
+	},
+}
+
+// getBuffer returns an empty buffer from the pool. Call putBuffer when the buffer is no longer needed.
+func getBuffer() *[]byte {
+	b := packetBuffers.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+// putBuffer returns a buffer to the pool.
+func putBuffer(b *[]byte) {
+	packetBuffers.Put(b)
+}
+
+// appendEcho appends an icmp echo request of the provided type to b and returns the extended buffer.
+// This produces the same packet as icmp.Message's Marshal, without allocating a new buffer for each packet.
+//
+// For IPv4, we calculate the checksum. For IPv6, the kernel calculates it, as it covers the IPv6 pseudo header.
+func appendEcho(b []byte, icmpType byte, v6 bool, id int, seq SequenceNumber, p payload, data []byte) []byte {
+	start := len(b)
+	b = append(b, icmpType, 0, 0, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(id))
+	b = binary.BigEndian.AppendUint16(b, uint16(seq))
+	b = p.append(b, data)
+	if !v6 {
+		binary.BigEndian.PutUint16(b[start+2:start+4], icmpChecksum(b[start:]))
+	}
+	return b
+}
+
+// icmpChecksum calculates the internet checksum (RFC 1071) of an icmp message.
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	sum = sum>>16 + sum&0xffff
+	sum += sum >> 16
+	return ^uint16(sum)
+}
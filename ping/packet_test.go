@@ -0,0 +1,36 @@
+package ping
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestAppendEcho(t *testing.T) {
+	p := payload{nonce: 1, timeSent: time.Now()}
+	for _, data := range [][]byte{nil, []byte("payload"), []byte("odd")} {
+		// IPv4: same packet as icmp.Message, including the checksum
+		want, _ := (&icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 10, Seq: 20, Data: p.marshal(data)}}).Marshal(nil)
+		got := appendEcho([]byte("prefix"), byte(ipv4.ICMPTypeEcho), false, 10, 20, p, data)
+		assert.Equal(t, want, got[len("prefix"):])
+
+		// IPv6: the kernel calculates the checksum
+		want, _ = (&icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: 10, Seq: 20, Data: p.marshal(data)}}).Marshal(nil)
+		got = appendEcho(nil, byte(ipv6.ICMPTypeEchoRequest), true, 10, 20, p, data)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestBuffers(t *testing.T) {
+	b := getBuffer()
+	*b = append(*b, "payload"...)
+	putBuffer(b)
+	b = getBuffer()
+	assert.Empty(t, *b)
+	assert.Equal(t, maxPacketSize, cap(*b))
+	putBuffer(b)
+}
@@ -2,8 +2,12 @@ package ping
 
 import (
 	"encoding/binary"
+	"log/slog"
 	"net"
+	"slices"
 	"testing"
+	"testing/synctest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,9 +16,10 @@ import (
 	"golang.org/x/net/ipv6"
 )
 
-func TestParseTimeExceeded(t *testing.T) {
+func TestParseOriginalRequest(t *testing.T) {
 	type want struct {
 		err require.ErrorAssertionFunc
+		dst net.IP
 		id  int
 		seq SequenceNumber
 	}
@@ -33,16 +38,17 @@ func TestParseTimeExceeded(t *testing.T) {
 				// Fake IPv4 header (20 bytes, IHL=5)
 				ipHeader := make([]byte, ipv4.HeaderLen)
 				ipHeader[0] = (4 << 4) | 5 // Version 4, IHL=5 (20 bytes)
+				copy(ipHeader[16:], net.IPv4(10, 0, 0, 1).To4())
 				return append(ipHeader, raw...), net.IPv4(127, 0, 0, 1)
 			},
-			want: want{require.NoError, 1, 2},
+			want: want{require.NoError, net.IPv4(10, 0, 0, 1), 1, 2},
 		},
 		{
 			name: "ipv4 too short",
 			build: func() ([]byte, net.IP) {
 				return make([]byte, ipv4.HeaderLen+7), net.IPv4(127, 0, 0, 1)
 			},
-			want: want{require.Error, 0, 0},
+			want: want{require.Error, nil, 0, 0},
 		},
 		{
 			name: "ipv6 success",
@@ -52,9 +58,11 @@ func TestParseTimeExceeded(t *testing.T) {
 				msg := &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: echo}
 				raw, _ := msg.Marshal(nil)
 				// Prepend IPv6 header
-				return append(make([]byte, ipv6.HeaderLen), raw...), net.IPv6loopback
+				ipHeader := make([]byte, ipv6.HeaderLen)
+				copy(ipHeader[24:], net.ParseIP("fd00::1"))
+				return append(ipHeader, raw...), net.IPv6loopback
 			},
-			want: want{require.NoError, 1, 2},
+			want: want{require.NoError, net.ParseIP("fd00::1"), 1, 2},
 		},
 		{
 			name: "ipv6 fallback to raw bytes",
@@ -66,24 +74,412 @@ func TestParseTimeExceeded(t *testing.T) {
 				// Prepend IPv6 header
 				return append(make([]byte, ipv6.HeaderLen), inner...), net.IPv6loopback
 			},
-			want: want{require.NoError, 1, 2},
+			want: want{require.NoError, net.IPv6unspecified, 1, 2},
 		},
 		{
 			name: "ipv6 too short",
 			build: func() ([]byte, net.IP) {
 				return make([]byte, ipv6.HeaderLen+7), net.IPv6loopback
 			},
-			want: want{require.Error, 0, 0},
+			want: want{require.Error, nil, 0, 0},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			data, src := tt.build()
-			gotID, gotSeq, err := parseTimeExceeded(data, src)
+			gotDst, gotID, gotSeq, err := parseOriginalRequest(data, src)
 			tt.want.err(t, err)
+			assert.Equal(t, tt.want.dst, gotDst)
 			assert.Equal(t, tt.want.id, gotID)
 			assert.Equal(t, tt.want.seq, gotSeq)
 		})
 	}
 }
+
+func TestSocket_parsePacket(t *testing.T) {
+	const id = 1
+	const nonce = 0xdeadbeef
+	data := payload{nonce: nonce, timeSent: time.Now()}.marshal([]byte("payload"))
+	corrupted := slices.Clone(data)
+	corrupted[len(corrupted)-1]++
+	v4Target := net.ParseIP("10.0.0.1")
+	v6Target := net.ParseIP("fd00::1")
+	router4 := net.ParseIP("192.168.0.1")
+	router6 := net.ParseIP("fd00::ff")
+
+	// original returns the original datagram, as embedded in an icmp error message
+	original := func(target net.IP, id, seq int) []byte {
+		var msg icmp.Message
+		var hdr []byte
+		if target.To4() != nil {
+			msg = icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: id, Seq: seq}}
+			hdr = make([]byte, ipv4.HeaderLen)
+			hdr[0] = (4 << 4) | 5
+			copy(hdr[16:], target.To4())
+		} else {
+			msg = icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: id, Seq: seq}}
+			hdr = make([]byte, ipv6.HeaderLen)
+			copy(hdr[24:], target)
+		}
+		raw, _ := msg.Marshal(nil)
+		return append(hdr, raw...)
+	}
+
+	tests := []struct {
+		name          string
+		protocol      int
+		msg           icmp.Message
+		from          net.IP
+		wantErr       require.ErrorAssertionFunc
+		wantType      ResponseType
+		wantSeq       SequenceNumber
+		wantCode      int
+		wantCorrupted bool
+	}{
+		{
+			name:     "echo reply",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 1, Data: data}},
+			from:     v4Target,
+			wantErr:  require.NoError,
+			wantType: ResponseEchoReply,
+			wantSeq:  1,
+		},
+		{
+			name:     "duplicate echo reply",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 3, Data: data}},
+			from:     v4Target,
+			wantErr:  require.NoError,
+			wantType: ResponseDuplicate,
+			wantSeq:  3,
+		},
+		{
+			name:     "late echo reply",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 4, Data: data}},
+			from:     v4Target,
+			wantErr:  require.NoError,
+			wantType: ResponseLate,
+			wantSeq:  4,
+		},
+		{
+			name:     "foreign echo reply",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("payload")}},
+			from:     v4Target,
+			wantErr: func(t require.TestingT, err error, _ ...any) {
+				require.ErrorIs(t, err, errForeignPayload)
+			},
+		},
+		{
+			name:          "corrupted echo reply",
+			protocol:      1,
+			msg:           icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 1, Data: corrupted}},
+			from:          v4Target,
+			wantErr:       require.NoError,
+			wantType:      ResponseEchoReply,
+			wantSeq:       1,
+			wantCorrupted: true,
+		},
+		{
+			name:     "echo request",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: id, Seq: 1}},
+			from:     v4Target,
+			wantErr: func(t require.TestingT, err error, _ ...any) {
+				require.ErrorIs(t, err, errUnsupportedType)
+			},
+		},
+		{
+			name:     "incorrect id",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id + 1, Seq: 1, Data: data}},
+			from:     v4Target,
+			wantErr: func(t require.TestingT, err error, _ ...any) {
+				require.ErrorAs(t, err, new(errIncorrectID))
+			},
+		},
+		{
+			name:     "no request",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 10, Data: data}},
+			from:     v4Target,
+			wantErr:  require.NoError,
+			wantType: ResponseUnmatched,
+			wantSeq:  10,
+		},
+		{
+			name:     "no request for time exceeded",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: original(v4Target, id, 10)}},
+			from:     router4,
+			wantErr:  require.Error,
+		},
+		{
+			name:     "ipv4 time exceeded",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: original(v4Target, id, 1)}},
+			from:     router4,
+			wantErr:  require.NoError,
+			wantType: ResponseTimeExceeded,
+			wantSeq:  1,
+		},
+		{
+			name:     "ipv4 port unreachable",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: original(v4Target, id, 1)}},
+			from:     v4Target,
+			wantErr:  require.NoError,
+			wantType: ResponseDestinationUnreachable,
+			wantSeq:  1,
+			wantCode: 3,
+		},
+		{
+			name:     "ipv4 fragmentation needed",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 4, Body: &icmp.DstUnreach{Data: original(v4Target, id, 1)}},
+			from:     router4,
+			wantErr:  require.NoError,
+			wantType: ResponsePacketTooBig,
+			wantSeq:  1,
+			wantCode: 4,
+		},
+		{
+			name:     "ipv4 parameter problem",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeParameterProblem, Body: &icmp.ParamProb{Data: original(v4Target, id, 1)}},
+			from:     router4,
+			wantErr:  require.NoError,
+			wantType: ResponseParameterProblem,
+			wantSeq:  1,
+		},
+		{
+			name:     "ipv4 unsupported type",
+			protocol: 1,
+			msg:      icmp.Message{Type: ipv4.ICMPTypeRouterAdvertisement, Body: &icmp.RawBody{Data: []byte{0, 0, 0, 0}}},
+			from:     router4,
+			wantErr: func(t require.TestingT, err error, _ ...any) {
+				require.ErrorIs(t, err, errUnsupportedType)
+			},
+		},
+		{
+			name:     "ipv6 admin prohibited",
+			protocol: 58,
+			msg:      icmp.Message{Type: ipv6.ICMPTypeDestinationUnreachable, Code: 1, Body: &icmp.DstUnreach{Data: original(v6Target, id, 2)}},
+			from:     router6,
+			wantErr:  require.NoError,
+			wantType: ResponseDestinationUnreachable,
+			wantSeq:  2,
+			wantCode: 1,
+		},
+		{
+			name:     "ipv6 packet too big",
+			protocol: 58,
+			msg:      icmp.Message{Type: ipv6.ICMPTypePacketTooBig, Body: &icmp.PacketTooBig{MTU: 1280, Data: original(v6Target, id, 2)}},
+			from:     router6,
+			wantErr:  require.NoError,
+			wantType: ResponsePacketTooBig,
+			wantSeq:  2,
+		},
+		{
+			name:     "ipv6 parameter problem",
+			protocol: 58,
+			msg:      icmp.Message{Type: ipv6.ICMPTypeParameterProblem, Code: 1, Body: &icmp.ParamProb{Data: original(v6Target, id, 2)}},
+			from:     router6,
+			wantErr:  require.NoError,
+			wantType: ResponseParameterProblem,
+			wantSeq:  2,
+			wantCode: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(WithLogger(slog.New(slog.DiscardHandler)))
+			require.NoError(t, err)
+			s.id = id
+			s.nonce = nonce
+			s.outstandingRequests[s.requestKey(v4Target, id, 1)] = request{handle: s.handle, Request: Request{Target: v4Target, Seq: 1, TimeSent: time.Now()}}
+			s.outstandingRequests[s.requestKey(v6Target, id, 2)] = request{handle: s.handle, Request: Request{Target: v6Target, Seq: 2, TimeSent: time.Now()}}
+			s.repliedRequests[s.requestKey(v4Target, id, 3)] = request{handle: s.handle, Request: Request{Target: v4Target, Seq: 3, TimeSent: time.Now()}}
+			s.expiredRequests[s.requestKey(v4Target, id, 4)] = request{handle: s.handle, Request: Request{Target: v4Target, Seq: 4, TimeSent: time.Now()}}
+
+			data, err := tt.msg.Marshal(nil)
+			require.NoError(t, err)
+			resp, err := s.parsePacket(tt.protocol, data, tt.from, time.Now())
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantType, resp.ResponseType)
+			assert.Equal(t, tt.wantSeq, resp.Request.Seq)
+			assert.Equal(t, tt.wantCode, resp.Code)
+			assert.Equal(t, tt.wantCorrupted, resp.Corrupted)
+			assert.Equal(t, tt.from, resp.From)
+		})
+	}
+}
+
+func TestSocket_parsePacket_Lockstep(t *testing.T) {
+	const id = 1
+	const targetCount = 100
+	s, err := New(WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+	s.id = id
+	s.nonce = 1
+
+	// all targets have an outstanding request with the same sequence number, but a different time sent
+	targets := make([]net.IP, targetCount)
+	now := time.Now()
+	for i := range targets {
+		targets[i] = net.IPv4(10, 0, 0, byte(i+1))
+		s.outstandingRequests[s.requestKey(targets[i], id, 1)] = request{handle: s.handle, Request: Request{Target: targets[i], Seq: 1, TimeSent: now.Add(-time.Duration(i+1) * time.Second)}}
+	}
+
+	// targets reply in reverse order
+	for i := len(targets) - 1; i >= 0; i-- {
+		msg := icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 1, Data: payload{nonce: 1, timeSent: now}.marshal(nil)}}
+		data, _ := msg.Marshal(nil)
+		resp, err := s.parsePacket(1, data, targets[i], time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, targets[i], resp.Request.Target)
+		assert.Equal(t, now.Add(-time.Duration(i+1)*time.Second), resp.Request.TimeSent)
+		assert.GreaterOrEqual(t, resp.Latency, time.Duration(i+1)*time.Second)
+
+		// a second reply is a duplicate and is not matched to another target's request
+		resp, err = s.parsePacket(1, data, targets[i], time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, ResponseDuplicate, resp.ResponseType)
+		assert.Equal(t, targets[i], resp.Request.Target)
+	}
+	assert.Empty(t, s.outstandingRequests)
+}
+
+func TestSocket_timeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		const id = 1
+		const nonce = 1
+		s, err := New(WithTimeout(time.Second), WithGracePeriod(time.Second), WithLogger(slog.New(slog.DiscardHandler)))
+		require.NoError(t, err)
+		s.id = id
+		s.nonce = nonce
+		target := net.ParseIP("10.0.0.1")
+		s.track(s.requestKey(target, id, 1), request{handle: s.handle, Request: Request{Target: target, Seq: 1, TimeSent: time.Now()}})
+		reply, _ := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 1, Data: payload{nonce: nonce, timeSent: time.Now()}.marshal(nil)}}).Marshal(nil)
+
+		// the request times out
+		time.Sleep(1500 * time.Millisecond)
+		s.timeout()
+		resp, err := s.Read(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, ResponseTimeout, resp.ResponseType)
+
+		// the reply arrives during the grace period: it's late
+		late, err := s.parsePacket(1, reply, target, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, ResponseLate, late.ResponseType)
+		assert.Equal(t, 1500*time.Millisecond, late.Latency)
+
+		// a second reply is a duplicate
+		late, err = s.parsePacket(1, reply, target, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, ResponseDuplicate, late.ResponseType)
+
+		// after the grace period, the request is forgotten: the latency is based on the payload
+		time.Sleep(time.Second)
+		s.timeout()
+		late, err = s.parsePacket(1, reply, target, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, ResponseUnmatched, late.ResponseType)
+		assert.Equal(t, 2500*time.Millisecond, late.Latency)
+		assert.Nil(t, late.handle)
+		assert.Empty(t, s.repliedRequests)
+		assert.Empty(t, s.expiredRequests)
+	})
+}
+
+func TestSocket_timeout_PerRequest(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s, err := New(WithTimeout(5*time.Second), WithLogger(slog.New(slog.DiscardHandler)))
+		require.NoError(t, err)
+		target := net.ParseIP("10.0.0.1")
+		s.track(s.requestKey(target, 1, 1), request{handle: s.handle, Request: Request{Target: target, Seq: 1, TimeSent: time.Now(), Timeout: 200 * time.Millisecond}})
+		s.track(s.requestKey(target, 1, 2), request{handle: s.handle, Request: Request{Target: target, Seq: 2, TimeSent: time.Now()}})
+
+		// the next check is scheduled when the first request times out
+		assert.Equal(t, 200*time.Millisecond, s.timeout())
+		time.Sleep(200 * time.Millisecond)
+		assert.Equal(t, timeoutInterval, s.timeout())
+		resp, err := s.Read(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, ResponseTimeout, resp.ResponseType)
+		assert.Equal(t, SequenceNumber(1), resp.Request.Seq)
+
+		// the second request uses the Socket's timeout
+		time.Sleep(4 * time.Second)
+		assert.Equal(t, 800*time.Millisecond, s.timeout())
+		time.Sleep(800 * time.Millisecond)
+		s.timeout()
+		resp, err = s.Read(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, SequenceNumber(2), resp.Request.Seq)
+		assert.Empty(t, s.outstandingRequests)
+	})
+}
+
+func TestSocket_track(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s, err := New(WithTimeout(5*time.Second), WithLogger(slog.New(slog.DiscardHandler)))
+		require.NoError(t, err)
+		s.id = 1
+		target := net.ParseIP("10.0.0.1")
+		assert.Equal(t, timeoutInterval, s.timeout())
+
+		// requests that expire after the next timeout check don't wake up Serve
+		assert.False(t, s.track(s.requestKey(target, 1, 1), request{handle: s.handle, Request: Request{Target: target, Seq: 1, TimeSent: time.Now()}}))
+		// requests that expire earlier do, but only once
+		assert.True(t, s.track(s.requestKey(target, 1, 2), request{handle: s.handle, Request: Request{Target: target, Seq: 2, TimeSent: time.Now(), Timeout: time.Second}}))
+		assert.False(t, s.track(s.requestKey(target, 1, 3), request{handle: s.handle, Request: Request{Target: target, Seq: 3, TimeSent: time.Now(), Timeout: time.Second}}))
+		assert.Equal(t, time.Second, s.timeout())
+
+		// a request that received a reply doesn't time out
+		reply, _ := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 2, Data: payload{nonce: s.nonce, timeSent: time.Now()}.marshal(nil)}}).Marshal(nil)
+		_, err = s.parsePacket(1, reply, target, time.Now())
+		require.NoError(t, err)
+		time.Sleep(time.Second)
+		s.timeout()
+		resp, err := s.Read(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, ResponseTimeout, resp.ResponseType)
+		assert.Equal(t, SequenceNumber(3), resp.Request.Seq)
+		assert.Len(t, s.outstandingRequests, 1)
+	})
+}
+
+func TestSocket_nextSeq(t *testing.T) {
+	s, err := New(WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+	target := net.ParseIP("10.0.0.1")
+
+	// sequence numbers that are still in use are skipped
+	s.outstandingRequests[s.requestKey(target, int(s.id), 1)] = request{handle: s.handle, Request: Request{Target: target}}
+	s.expiredRequests[s.requestKey(target, int(s.id), 2)] = request{handle: s.handle, Request: Request{Target: target}}
+	seq, err := s.nextSeq(target)
+	require.NoError(t, err)
+	assert.Equal(t, SequenceNumber(3), seq)
+
+	// other targets are not affected
+	s.seq = 0
+	seq, err = s.nextSeq(net.ParseIP("10.0.0.2"))
+	require.NoError(t, err)
+	assert.Equal(t, SequenceNumber(1), seq)
+
+	// all sequence numbers are in use
+	for seq := range 1 << 16 {
+		s.repliedRequests[s.requestKey(target, int(s.id), SequenceNumber(seq))] = request{handle: s.handle}
+	}
+	_, err = s.nextSeq(target)
+	assert.Error(t, err)
+}
@@ -0,0 +1,65 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+// payloadHeaderLen is the size of the header that Send adds in front of the data of each echo request.
+const payloadHeaderLen = 20
+
+var (
+	// errForeignPayload is returned when an echo reply doesn't carry the Socket's nonce, i.e., it's a reply to a request
+	// sent by another process.
+	errForeignPayload = errors.New("payload not sent by this socket")
+	// errCorruptPayload is returned when the checksum of an echo reply's payload doesn't match its content.
+	errCorruptPayload = errors.New("payload checksum mismatch")
+)
+
+// payload is the header that the Socket adds to the data of each echo request. The target returns the data unchanged
+// in its echo reply, so the header allows us to verify that the reply is for one of our own requests and to determine
+// when the request was sent, even if we no longer have a record of the request.
+//
+// Layout: nonce (8 bytes) | time sent (8 bytes, unix nanoseconds) | checksum (4 bytes, crc32) | data
+type payload struct {
+	timeSent time.Time
+	nonce    uint64
+}
+
+// marshal returns the header, followed by the provided data.
+func (p payload) marshal(data []byte) []byte {
+	return p.append(make([]byte, 0, payloadHeaderLen+len(data)), data)
+}
+
+// append appends the header, followed by the provided data, to b and returns the extended buffer.
+func (p payload) append(b, data []byte) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint64(b, p.nonce)
+	b = binary.BigEndian.AppendUint64(b, uint64(p.timeSent.UnixNano()))
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, data...)
+	binary.BigEndian.PutUint32(b[start+16:start+20], payloadChecksum(b[start:]))
+	return b
+}
+
+// parsePayload parses the payload of an echo reply. It returns errForeignPayload if the payload wasn't sent by a Socket
+// with the provided nonce and errCorruptPayload if the payload was modified in transit.
+func parsePayload(b []byte, nonce uint64) (payload, error) {
+	if len(b) < payloadHeaderLen || binary.BigEndian.Uint64(b[0:8]) != nonce {
+		return payload{}, errForeignPayload
+	}
+	if binary.BigEndian.Uint32(b[16:20]) != payloadChecksum(b) {
+		return payload{}, errCorruptPayload
+	}
+	return payload{
+		nonce:    nonce,
+		timeSent: time.Unix(0, int64(binary.BigEndian.Uint64(b[8:16]))),
+	}, nil
+}
+
+// payloadChecksum calculates the checksum of a payload. It covers everything but the checksum itself.
+func payloadChecksum(b []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(b[:16]), crc32.IEEETable, b[payloadHeaderLen:])
+}
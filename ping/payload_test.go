@@ -0,0 +1,35 @@
+package ping
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayload(t *testing.T) {
+	const nonce = 0x0123456789abcdef
+	timeSent := time.Date(2025, 1, 1, 12, 0, 0, 123, time.UTC)
+	b := payload{nonce: nonce, timeSent: timeSent}.marshal([]byte("payload"))
+	require.Len(t, b, payloadHeaderLen+len("payload"))
+	assert.Equal(t, "payload", string(b[payloadHeaderLen:]))
+
+	p, err := parsePayload(b, nonce)
+	require.NoError(t, err)
+	assert.True(t, timeSent.Equal(p.timeSent))
+
+	// reply to another socket
+	_, err = parsePayload(b, nonce+1)
+	assert.ErrorIs(t, err, errForeignPayload)
+	_, err = parsePayload(b[:payloadHeaderLen-1], nonce)
+	assert.ErrorIs(t, err, errForeignPayload)
+
+	// modified in transit
+	for _, offset := range []int{8, 16, payloadHeaderLen} {
+		corrupted := append([]byte(nil), b...)
+		corrupted[offset] ^= 0xff
+		_, err = parsePayload(corrupted, nonce)
+		assert.ErrorIs(t, err, errCorruptPayload, offset)
+	}
+}
@@ -1,15 +1,20 @@
-// Package ping sends and receives icmp echo request/reply packets over a UDP socket.
+// Package ping sends and receives icmp echo request/reply packets over an unprivileged datagram socket or a raw socket.
 // Both IPv4 and IPv6 are supported.
 package ping
 
 import (
+	"cmp"
+	"container/heap"
 	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net"
+	"net/netip"
 	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,15 +25,24 @@ import (
 )
 
 const (
-	// defaultReadTimeout is the default timeout for reading icmp packets from the socket.
-	defaultReadTimeout = 5 * time.Second
+	// defaultTimeout is the default time we wait for a response to a request.
+	defaultTimeout = 5 * time.Second
+	// DefaultGracePeriod is the default time we keep a record of requests after they time out or receive a reply.
+	DefaultGracePeriod = 5 * time.Second
 	// timeoutInterval determines how often we check for expired outstanding requests.
 	timeoutInterval = 2 * time.Second
 )
 
 var (
-	ErrTimeout = errors.New("timeout waiting for response")
+	// ErrPacketTooBig is returned by Send when the packet exceeds the MTU of the outgoing interface and the Socket
+	// doesn't allow fragmentation (see WithDontFragment).
+	ErrPacketTooBig = errors.New("packet too big")
+	// ErrNoICMPErrors is returned by Trace and DiscoverPMTU if the Socket doesn't receive icmp errors for the target
+	// (see ReceivesErrors).
+	ErrNoICMPErrors = errors.New("socket doesn't receive icmp errors: use a raw socket")
 	//errIncorrectID = errors.New("packet ignored: incorrect ID")
+	// errUnsupportedType is returned when an icmp packet is received of a type that we don't process.
+	errUnsupportedType = errors.New("unsupported message type")
 )
 
 // errIncorrectID is an error returned when an icmp packet is received with an incorrect ID.
@@ -55,6 +69,28 @@ type Response struct {
 	Request      Request
 	ResponseType ResponseType
 	Latency      time.Duration
+	// Code is the icmp code of the response. For icmp error messages, it details the reason for the error. See Reason.
+	Code int
+	// TimestampSource tells how the receive time, used to calculate Latency, was determined.
+	TimestampSource TimestampSource
+	// Corrupted is true if the payload of an echo reply was modified in transit.
+	Corrupted bool
+	// MTU is the next-hop MTU reported in a ResponsePacketTooBig. Zero if the router didn't report it.
+	MTU int
+	// TTL is the TTL (IPv4) or hop limit (IPv6) of the received packet. Zero if unknown.
+	TTL uint8
+}
+
+// Reason returns a description of the response's icmp code, e.g. "port unreachable".
+func (r Response) Reason() string {
+	codes := ipv6Codes
+	if r.From.To4() != nil {
+		codes = ipv4Codes
+	}
+	if reason, ok := codes[r.ResponseType][r.Code]; ok {
+		return reason
+	}
+	return fmt.Sprintf("code %d", r.Code)
 }
 
 func (r Response) LogValue() slog.Value {
@@ -70,18 +106,52 @@ func (r Response) LogValue() slog.Value {
 	return slog.GroupValue(attrs...)
 }
 
+// requestKey identifies an outstanding request.
+// Different targets may use the same sequence numbers, so we need the target address as well as the sequence number.
+type requestKey struct {
+	target netip.Addr
+	id     int
+	seq    SequenceNumber
+}
+
+// request is a Request, along with the Handle that sent it.
+type request struct {
+	handle *Handle
+	Request
+}
+
+// response is a Response, along with the Handle it's for.
+type response struct {
+	handle *Handle
+	Response
+}
+
 // Request represents an icmp packet sent by the Socket.
 type Request struct {
 	TimeSent time.Time
 	Target   net.IP
 	Seq      SequenceNumber
 	TTL      uint8
+	// Timeout is how long the Socket waits for a response before it reports a ResponseTimeout.
+	// If zero, the Socket's Timeout is used.
+	Timeout time.Duration
 }
 
 const (
 	ResponseEchoReply ResponseType = iota
 	ResponseTimeExceeded
 	ResponseTimeout
+	ResponseDestinationUnreachable
+	ResponsePacketTooBig
+	ResponseParameterProblem
+	// ResponseDuplicate is an echo reply for a request that already received an echo reply.
+	ResponseDuplicate
+	// ResponseLate is an echo reply for a request that already timed out.
+	ResponseLate
+	// ResponseUnmatched is an echo reply for a request the Socket no longer knows about, e.g. because it arrived after
+	// the grace period. Its Latency is based on the send time in the payload. Request only holds the Target, the
+	// sequence number used on the wire and TimeSent. As no Handle sent it, only Subscriptions receive it.
+	ResponseUnmatched
 )
 
 type ResponseType int
@@ -94,39 +164,164 @@ func (rt ResponseType) String() string {
 		return "time exceeded"
 	case ResponseTimeout:
 		return "timeout"
+	case ResponseDestinationUnreachable:
+		return "destination unreachable"
+	case ResponsePacketTooBig:
+		return "packet too big"
+	case ResponseParameterProblem:
+		return "parameter problem"
+	case ResponseDuplicate:
+		return "duplicate"
+	case ResponseLate:
+		return "late"
+	case ResponseUnmatched:
+		return "unmatched"
 	default:
 		return "unknown"
 	}
 }
 
+// IsError returns true if the response type is an icmp error message (other than time exceeded) sent back for a request.
+func (rt ResponseType) IsError() bool {
+	return rt == ResponseDestinationUnreachable || rt == ResponsePacketTooBig || rt == ResponseParameterProblem
+}
+
+// ipv4Codes describes the icmp codes for IPv4 (RFC 792, RFC 1122, RFC 1812).
+var ipv4Codes = map[ResponseType]map[int]string{
+	ResponseTimeExceeded: {
+		0: "ttl exceeded in transit",
+		1: "fragment reassembly time exceeded",
+	},
+	ResponseDestinationUnreachable: {
+		0:  "net unreachable",
+		1:  "host unreachable",
+		2:  "protocol unreachable",
+		3:  "port unreachable",
+		5:  "source route failed",
+		6:  "destination network unknown",
+		7:  "destination host unknown",
+		8:  "source host isolated",
+		9:  "net administratively prohibited",
+		10: "host administratively prohibited",
+		11: "net unreachable for tos",
+		12: "host unreachable for tos",
+		13: "communication administratively prohibited",
+		14: "host precedence violation",
+		15: "precedence cutoff in effect",
+	},
+	ResponsePacketTooBig: {
+		4: "fragmentation needed",
+	},
+	ResponseParameterProblem: {
+		0: "pointer indicates the error",
+		1: "missing a required option",
+		2: "bad length",
+	},
+}
+
+// ipv6Codes describes the icmp codes for IPv6 (RFC 4443).
+var ipv6Codes = map[ResponseType]map[int]string{
+	ResponseTimeExceeded: {
+		0: "hop limit exceeded in transit",
+		1: "fragment reassembly time exceeded",
+	},
+	ResponseDestinationUnreachable: {
+		0: "no route to destination",
+		1: "communication administratively prohibited",
+		2: "beyond scope of source address",
+		3: "address unreachable",
+		4: "port unreachable",
+		5: "source address failed ingress/egress policy",
+		6: "reject route to destination",
+	},
+	ResponsePacketTooBig: {
+		0: "packet too big",
+	},
+	ResponseParameterProblem: {
+		0: "erroneous header field",
+		1: "unrecognized next header type",
+		2: "unrecognized ipv6 option",
+	},
+}
+
 type Socket struct {
-	v4                  *icmp.PacketConn
-	v6                  *icmp.PacketConn
-	q                   *queue[Response]
+	v4                  *conn
+	v6                  *conn
+	subscribers         subscribers
+	handle              *Handle
 	logger              *slog.Logger
-	outstandingRequests map[SequenceNumber]Request
-	Timeout             time.Duration
-	lock                sync.Mutex
-	id                  uint16
-	checkID             bool
+	outstandingRequests map[requestKey]request
+	// repliedRequests holds the requests that received an echo reply, so we can detect duplicate replies.
+	repliedRequests map[requestKey]request
+	// expiredRequests holds the requests that timed out, so we can detect late replies.
+	expiredRequests map[requestKey]request
+	// deadlines holds the time each request times out and the time it's forgotten.
+	deadlines deadlines
+	// nextCheck is the time Serve checks for expired requests. A request that expires earlier wakes up Serve.
+	nextCheck        time.Time
+	Timeout          time.Duration
+	wakeup           chan struct{}
+	gracePeriod      time.Duration
+	source           net.IP
+	iface            string
+	mark             uint32
+	netns            string
+	tos              uint8
+	dontFragment     bool
+	batchSize        int
+	lock             sync.Mutex
+	nonce            uint64
+	mode             SocketMode
+	id               uint16
+	seq              SequenceNumber
+	checkID          bool
+	ipv4             bool
+	ipv6             bool
+	kernelTimestamps bool
 }
 
 // New creates a new Socket instance.
 func New(opts ...SocketOption) (*Socket, error) {
 	s := Socket{
-		q:                   newQueue[Response](),
+		wakeup:              make(chan struct{}, 1),
 		logger:              slog.Default(),
-		Timeout:             defaultReadTimeout,
+		Timeout:             defaultTimeout,
 		id:                  uint16(atomic.AddUint32(&nextID, 1) & 0xffff),
-		outstandingRequests: make(map[SequenceNumber]Request),
+		outstandingRequests: make(map[requestKey]request),
+		repliedRequests:     make(map[requestKey]request),
+		expiredRequests:     make(map[requestKey]request),
+		gracePeriod:         DefaultGracePeriod,
+		nonce:               rand.Uint64(),
 		checkID:             true,
 	}
+	s.handle = s.Register(defaultSubscriptionSize)
 	var errs error
 	for _, opt := range opts {
 		if err := opt(&s); err != nil {
 			errs = errors.Join(errs, err)
 		}
 	}
+	// a socket bound to a source address can only send packets of the same IP version
+	if s.source != nil {
+		isIPv4 := s.source.To4() != nil
+		if (isIPv4 && !s.ipv4) || (!isIPv4 && !s.ipv6) {
+			errs = errors.Join(errs, fmt.Errorf("source address %s: IP version not enabled", s.source))
+		}
+		s.ipv4, s.ipv6 = s.ipv4 && isIPv4, s.ipv6 && !isIPv4
+	}
+	// open the sockets once all options are known
+	if s.ipv4 {
+		var err error
+		if s.v4, err = s.listen(listenIPv4); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	if s.ipv6 {
+		var err error
+		if s.v6, err = s.listen(listenIPv6); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
 	return &s, errs
 }
 
@@ -134,17 +329,103 @@ type SocketOption func(*Socket) error
 
 func WithIPv4() SocketOption {
 	return func(s *Socket) error {
-		var err error
-		s.v4, err = icmp.ListenPacket("udp4", "0.0.0.0")
-		return err
+		s.ipv4 = true
+		return nil
 	}
 }
 
 func WithIPv6() SocketOption {
 	return func(s *Socket) error {
-		var err error
-		s.v6, err = icmp.ListenPacket("udp6", "::")
-		return err
+		s.ipv6 = true
+		return nil
+	}
+}
+
+// WithMode sets the type of icmp socket to open. The default is ModeDatagram.
+func WithMode(mode SocketMode) SocketOption {
+	return func(s *Socket) error {
+		s.mode = mode
+		return nil
+	}
+}
+
+// WithSourceAddress sends packets from the provided local IP address. Only the socket for the address's IP version is opened.
+func WithSourceAddress(ip net.IP) SocketOption {
+	return func(s *Socket) error {
+		if ip == nil {
+			return errors.New("invalid source address")
+		}
+		s.source = ip
+		return nil
+	}
+}
+
+// WithInterface sends packets from the provided network interface (SO_BINDTODEVICE), regardless of the routing table.
+// This is only supported on Linux.
+func WithInterface(name string) SocketOption {
+	return func(s *Socket) error {
+		s.iface = name
+		return nil
+	}
+}
+
+// WithMark sets the firewall mark (SO_MARK) of the packets sent by the Socket. With policy routing, this selects
+// the routing table used to send the packets. This is only supported on Linux and requires CAP_NET_ADMIN.
+func WithMark(mark uint32) SocketOption {
+	return func(s *Socket) error {
+		s.mark = mark
+		return nil
+	}
+}
+
+// WithNetNS opens the Socket's icmp sockets in the provided network namespace. name is either the name of a namespace
+// created with "ip netns add" (i.e. /var/run/netns/<name>) or the path of a namespace file (e.g. /proc/<pid>/ns/net).
+// Once opened, the sockets send and receive packets in that namespace. This is only supported on Linux and requires CAP_SYS_ADMIN.
+func WithNetNS(name string) SocketOption {
+	return func(s *Socket) error {
+		s.netns = name
+		return nil
+	}
+}
+
+// WithTOS sets the type of service (IPv4) or traffic class (IPv6) of the packets sent by the Socket.
+// The upper six bits hold the DSCP value (see ParseDSCP), i.e. WithTOS(dscp << 2).
+func WithTOS(tos uint8) SocketOption {
+	return func(s *Socket) error {
+		s.tos = tos
+		return nil
+	}
+}
+
+// WithDontFragment sets the don't fragment flag on all packets sent by the Socket and ignores the path MTU cached by
+// the kernel. This is required by DiscoverPMTU. Only supported on Linux.
+func WithDontFragment() SocketOption {
+	return func(s *Socket) error {
+		s.dontFragment = true
+		return nil
+	}
+}
+
+// WithBatchSize reads up to n packets from the icmp sockets with one system call (recvmmsg), rather than one packet
+// per call. This reduces the cost of receiving packets when pinging many targets. Only supported on Linux: on other
+// platforms, packets are read one at a time.
+func WithBatchSize(n int) SocketOption {
+	return func(s *Socket) error {
+		if n < 1 {
+			return fmt.Errorf("invalid batch size: %d", n)
+		}
+		s.batchSize = n
+		return nil
+	}
+}
+
+// WithKernelTimestamps uses the time the kernel received a packet, rather than the time the packet was read from the socket,
+// to calculate the latency. This removes any scheduling delays from the measured latency.
+// If the platform doesn't support kernel timestamps, the Socket falls back to userspace timestamps.
+func WithKernelTimestamps() SocketOption {
+	return func(s *Socket) error {
+		s.kernelTimestamps = true
+		return nil
 	}
 }
 
@@ -162,6 +443,17 @@ func WithTimeout(d time.Duration) SocketOption {
 	}
 }
 
+// WithGracePeriod sets how long the Socket keeps a record of a request after it timed out or received a reply.
+// During that period, a reply to a timed out request is reported as ResponseLate and a second reply is reported as ResponseDuplicate.
+func WithGracePeriod(d time.Duration) SocketOption {
+	return func(s *Socket) error {
+		s.gracePeriod = d
+		return nil
+	}
+}
+
+// WithoutCheckID doesn't check the ID of the received packets: replies are only matched by the payload's nonce.
+// Datagram sockets never check the ID, as the kernel replaces it.
 func WithoutCheckID() SocketOption {
 	return func(s *Socket) error {
 		s.checkID = false
@@ -195,267 +487,385 @@ func (s *Socket) Resolve(host string) (net.IP, error) {
 	return nil, fmt.Errorf("no IP support for %s", host)
 }
 
+// ReceivesErrors returns true if the Socket receives the icmp errors (time exceeded, destination unreachable, packet
+// too big and parameter problem) sent back for packets to the target. On Linux, datagram sockets don't: the kernel
+// only reports these on the socket's error queue. Use a raw socket (see WithMode) instead.
+func (s *Socket) ReceivesErrors(target net.IP) bool {
+	socket, err := s.conn(target)
+	return err == nil && socket.receivesErrors()
+}
+
 // Send creates an icmp packet with the provided seq, ttl and payload and sends it to the specified target.
-func (s *Socket) Send(target net.IP, seq SequenceNumber, ttl uint8, payload []byte) error {
-	// we're setting socket options, so only send one packet at a time
-	s.lock.Lock()
-	defer s.lock.Unlock()
+// The payload is prefixed with a header identifying the Socket and the time the packet was sent.
+//
+// Send uses a Handle that is created with the Socket: its responses are returned by Read. Other consumers of the
+// Socket should use their own Handle (see Register).
+func (s *Socket) Send(target net.IP, seq SequenceNumber, ttl uint8, data []byte) error {
+	return s.SendWithTimeout(target, seq, ttl, data, s.Timeout)
+}
 
-	// get the right socket & request type for the target's IP type (ipv4 or ipv6)
-	var socket *icmp.PacketConn
-	var requestType icmp.Type
-	switch {
-	case target.To4() != nil:
-		socket = s.v4
-		requestType = ipv4.ICMPTypeEcho
-	case target.To16() != nil:
-		socket = s.v6
-		requestType = ipv6.ICMPTypeEchoRequest
-	default:
-		return fmt.Errorf("unable to determine IP version for %q", target)
-	}
+// SendWithTimeout works like Send, but reports a ResponseTimeout if no response is received within the provided timeout,
+// rather than the Socket's Timeout.
+func (s *Socket) SendWithTimeout(target net.IP, seq SequenceNumber, ttl uint8, data []byte, timeout time.Duration) error {
+	return s.sendWithTimeout(s.handle, target, seq, ttl, data, timeout)
+}
 
-	// create the ICMP echo Request message
-	msg := icmp.Message{
-		Type: requestType,
-		Body: &icmp.Echo{
-			ID:   int(s.id),
-			Seq:  int(seq),
-			Data: payload,
-		},
-	}
-	data, _ := msg.Marshal(nil)
+func (s *Socket) sendWithTimeout(h *Handle, target net.IP, seq SequenceNumber, ttl uint8, data []byte, timeout time.Duration) error {
+	_, err := s.sendPackets(h, []Packet{{Target: target, Data: data, Timeout: timeout, Seq: seq, TTL: ttl}})
+	return err
+}
 
-	// if ttl is specified, set it on the socket
-	if ttl != 0 {
-		if err := s.setTTL(ttl); err != nil {
-			return fmt.Errorf("icmp socket failed to set ttl: %w", err)
+// nextSeq returns the sequence number to use on the wire for the next request to the target.
+// It skips any sequence number that is still in use, so responses are always matched to the right request.
+func (s *Socket) nextSeq(target net.IP) (SequenceNumber, error) {
+	for range 1 << 16 {
+		s.seq++
+		key := s.requestKey(target, int(s.id), s.seq)
+		_, outstanding := s.outstandingRequests[key]
+		_, replied := s.repliedRequests[key]
+		_, expired := s.expiredRequests[key]
+		if !outstanding && !replied && !expired {
+			return s.seq, nil
 		}
 	}
-
-	// send the packet
-	s.logger.Debug("sending packet", "addr", target, "ttl", ttl)
-	if _, err := socket.WriteTo(data, &net.UDPAddr{IP: target}); err != nil {
-		return err
-	}
-
-	// mark an outstanding packet for seq & time sent
-	s.outstandingRequests[seq] = Request{
-		Target:   target,
-		TTL:      ttl,
-		Seq:      seq,
-		TimeSent: time.Now(),
-	}
-	return nil
+	return 0, fmt.Errorf("no free sequence number for %s", target)
 }
 
-// Read reads the next icmp packet from the socket.
-// It blocks until a packet is received or the context is canceled.
+// Read returns the next response to a request sent with Send. It blocks until a response is received or the context is canceled.
+//
+// Responses to requests sent by other Handles are not returned. Consumers that need to see all responses should use
+// a Subscription.
 func (s *Socket) Read(ctx context.Context) (Response, error) {
-	subCtx, cancel := context.WithTimeout(ctx, s.Timeout)
-	defer cancel()
-
-	r, err := s.q.PopWait(subCtx)
-	if err != nil {
-		return Response{}, ErrTimeout
-	}
-	return r, nil
+	return s.handle.Read(ctx)
 }
 
 // Serve listens for icmp packets on the socket and dispatches them to the appropriate handler.
 // It's the responsibility of the caller to call Serve before sending or receiving packets.
 // Serve blocks until the context is canceled.
 func (s *Socket) Serve(ctx context.Context) {
-	ch := make(chan Response)
+	ch := make(chan response)
 	if s.v4 != nil {
 		go s.readPackets(ctx, s.v4, "IPv4", ch)
 	}
 	if s.v6 != nil {
 		go s.readPackets(ctx, s.v6, "IPv6", ch)
 	}
-	timeoutTicker := time.NewTicker(timeoutInterval)
-	defer timeoutTicker.Stop()
+	timeoutTimer := time.NewTimer(s.timeout())
+	defer timeoutTimer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-timeoutTicker.C:
-			s.timeout()
+		case <-timeoutTimer.C:
+			timeoutTimer.Reset(s.timeout())
+		case <-s.wakeup:
+			timeoutTimer.Reset(s.timeout())
 		case resp := <-ch:
-			s.lock.Lock()
-			// process the response:
-			// if not an outstanding packet, drop it
-			if _, ok := s.outstandingRequests[resp.Request.Seq]; !ok {
-				s.logger.Debug("ignoring packet", "seq", resp.Request.Seq)
-			} else {
-				// queue for delivery by Receive and remove the outstanding packet
-				s.q.Push(resp)
-			}
-			s.lock.Unlock()
+			s.deliver(resp)
 		}
 	}
 }
 
 // readPackets reads packets from the provided socket and parses the ICMP response.
-func (s *Socket) readPackets(ctx context.Context, socket *icmp.PacketConn, tp string, ch chan Response) {
+func (s *Socket) readPackets(ctx context.Context, socket *conn, tp string, ch chan response) {
 	logger := s.logger.With("transport", tp)
+	dispatch := func(resp response, err error) {
+		var err2 errIncorrectID
+		switch {
+		case errors.As(err, &err2):
+			logger.Debug("ignoring received packet", "err", err2, "id", s.id)
+		case errors.Is(err, errUnsupportedType) || errors.Is(err, errForeignPayload):
+			logger.Debug("ignoring received packet", "err", err)
+		case err != nil:
+			logger.Warn("failed to read packet", "err", err)
+		default:
+			if resp.Corrupted {
+				logger.Warn("echo reply payload corrupted in transit", "response", resp)
+			}
+			ch <- resp
+		}
+	}
+	// the buffers are reused for every packet
+	msgs := newBatch(max(1, s.batchSize))
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			response, err := s.readPacket(socket)
-			var err2 errIncorrectID
-			if errors.As(err, &err2) {
-				logger.Debug("ignoring received packet", "err", err2, "id", s.id)
-				continue
-			}
-			if err != nil {
-				logger.Warn("failed to read packet", "err", err)
-				break
+			if len(msgs) > 1 {
+				s.readBatch(socket, msgs, dispatch)
+			} else {
+				dispatch(s.readPacket(socket, msgs[0].Buffers[0], msgs[0].OOB))
 			}
-			ch <- response
 		}
 	}
 }
 
-func (s *Socket) readPacket(socket *icmp.PacketConn) (Response, error) {
+// readPacket reads one packet from the socket, using the provided buffers, and parses it.
+func (s *Socket) readPacket(socket *conn, buff, oob []byte) (response, error) {
 	if err := socket.SetReadDeadline(time.Now().Add(s.Timeout)); err != nil {
-		return Response{}, fmt.Errorf("failed to set deadline: %w", err)
+		return response{}, fmt.Errorf("failed to set deadline: %w", err)
 	}
-	const maxPacketSize = 1500
-	buff := make([]byte, maxPacketSize)
-	n, from, err := socket.ReadFrom(buff)
+	n, oobn, from, err := socket.readMsg(buff, oob)
 	if err != nil {
-		return Response{}, fmt.Errorf("read: %w", err)
-	}
-
-	var protocol int
-	switch {
-	case socket.IPv6PacketConn() != nil:
-		protocol = 58
-	case socket.IPv4PacketConn() != nil:
-		protocol = 1
-	default:
-		return Response{}, fmt.Errorf("unknown IP version")
+		return response{}, fmt.Errorf("read: %w", err)
 	}
+	received, source := receiveTime(oob[:oobn])
+	resp, err := s.parsePacket(socket.protocol(), buff[:n], from, received)
+	resp.TimestampSource = source
+	resp.TTL = socket.receivedTTL(oob[:oobn])
+	return resp, err
+}
 
+// parsePacket parses a received icmp packet and matches it to its outstanding request.
+// received is the time the packet was received and is used to calculate the latency.
+func (s *Socket) parsePacket(protocol int, data []byte, fromIP net.IP, received time.Time) (response, error) {
 	var msgID int
 	var respType ResponseType
 	var seq SequenceNumber
+	var timeSent time.Time
+	var corrupted bool
+	var mtu int
+	// for echo replies, the target is the sender. for error messages, we get it from the original request.
+	target := fromIP
 
-	resp, err := icmp.ParseMessage(protocol, buff[:n])
+	resp, err := icmp.ParseMessage(protocol, data)
 	if err != nil {
-		return Response{}, fmt.Errorf("parse: %w", err)
+		return response{}, fmt.Errorf("parse: %w", err)
 	}
 	switch body := resp.Body.(type) {
 	case *icmp.Echo:
+		if resp.Type != ipv4.ICMPTypeEchoReply && resp.Type != ipv6.ICMPTypeEchoReply {
+			// raw sockets also receive echo requests
+			return response{}, fmt.Errorf("%w: %v", errUnsupportedType, resp.Type)
+		}
 		respType = ResponseEchoReply
 		msgID = body.ID
 		seq = SequenceNumber(body.Seq)
+		// check that the reply is for one of our requests. other processes may use the same ID (see WithoutCheckID).
+		p, perr := parsePayload(body.Data, s.nonce)
+		switch {
+		case errors.Is(perr, errCorruptPayload):
+			corrupted = true
+		case perr != nil:
+			return response{}, perr
+		default:
+			timeSent = p.timeSent
+		}
 	case *icmp.TimeExceeded:
 		respType = ResponseTimeExceeded
-		msgID, seq, err = parseTimeExceeded(body.Data, from.(*net.UDPAddr).IP)
-		if err != nil {
-			return Response{}, fmt.Errorf("parse time exceeded payload: %w", err)
+		target, msgID, seq, err = parseOriginalRequest(body.Data, fromIP)
+	case *icmp.DstUnreach:
+		respType = ResponseDestinationUnreachable
+		if resp.Type == ipv4.ICMPTypeDestinationUnreachable && resp.Code == 4 {
+			// IPv4's equivalent of IPv6's packet too big
+			respType = ResponsePacketTooBig
+			// the next-hop MTU is stored in the second half of the unused field (RFC 1191)
+			mtu = int(binary.BigEndian.Uint16(data[6:8]))
 		}
+		target, msgID, seq, err = parseOriginalRequest(body.Data, fromIP)
+	case *icmp.PacketTooBig:
+		respType = ResponsePacketTooBig
+		mtu = body.MTU
+		target, msgID, seq, err = parseOriginalRequest(body.Data, fromIP)
+	case *icmp.ParamProb:
+		respType = ResponseParameterProblem
+		target, msgID, seq, err = parseOriginalRequest(body.Data, fromIP)
 	case *icmp.RawBody:
 		// drop these silently
+		return response{}, fmt.Errorf("%w: %v", errUnsupportedType, resp.Type)
 	default:
-		return Response{}, fmt.Errorf("unknown response type: %T", body)
+		return response{}, fmt.Errorf("unknown response type: %T", body)
+	}
+	if err != nil {
+		return response{}, fmt.Errorf("parse %s payload: %w", respType, err)
 	}
 
 	// if the packet is not for our id, drop it
 	if s.checkID && msgID != int(s.id) {
-		return Response{}, errIncorrectID{id: msgID}
+		return response{}, errIncorrectID{id: msgID}
 	}
 
-	// find back the original request
+	// find back the original request. we only expect one response per request, so remove it from the outstanding requests.
+	key := s.requestKey(target, msgID, seq)
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	req, ok := s.outstandingRequests[seq]
-	if !ok {
-		return Response{}, fmt.Errorf("no request found for seq %d", seq)
+	req, ok := s.outstandingRequests[key]
+	if ok {
+		delete(s.outstandingRequests, key)
+	} else if respType == ResponseEchoReply {
+		// the request either timed out or already received a reply
+		if req, ok = s.expiredRequests[key]; ok {
+			delete(s.expiredRequests, key)
+			respType = ResponseLate
+		} else if req, ok = s.repliedRequests[key]; ok {
+			respType = ResponseDuplicate
+		}
 	}
-
-	return Response{
-		ResponseType: respType,
-		From:         from.(*net.UDPAddr).IP,
-		Latency:      time.Since(s.outstandingRequests[seq].TimeSent),
-		Request:      req,
+	if !ok {
+		if respType == ResponseEchoReply && !timeSent.IsZero() {
+			// the payload tells us when the request was sent, even if we no longer have a record of it.
+			return response{
+				Response: Response{
+					ResponseType: ResponseUnmatched,
+					Code:         resp.Code,
+					From:         fromIP,
+					Latency:      received.Sub(timeSent),
+					Request:      Request{TimeSent: timeSent, Target: target, Seq: seq},
+				},
+			}, nil
+		}
+		return response{}, fmt.Errorf("no request found for target %s, seq %d", target, seq)
+	}
+	if respType == ResponseEchoReply || respType == ResponseLate {
+		s.repliedRequests[key] = req
+	}
+
+	return response{
+		handle: req.handle,
+		Response: Response{
+			ResponseType: respType,
+			Code:         resp.Code,
+			From:         fromIP,
+			Latency:      received.Sub(req.TimeSent),
+			Request:      req.Request,
+			Corrupted:    corrupted,
+			MTU:          mtu,
+		},
 	}, nil
 }
 
+// track records an outstanding request and schedules its timeout. It returns true if the request times out before
+// the next scheduled timeout check, i.e. if Serve needs to be woken up. The caller must hold s.lock.
+func (s *Socket) track(key requestKey, req request) bool {
+	s.outstandingRequests[key] = req
+	expires := s.expires(req)
+	heap.Push(&s.deadlines, deadline{at: expires, key: key})
+	heap.Push(&s.deadlines, deadline{at: expires.Add(s.gracePeriod), key: key, purge: true})
+	if expires.Before(s.nextCheck) {
+		s.nextCheck = expires
+		return true
+	}
+	return false
+}
+
 // timeout removes any outstanding packets that have timed out and queue a timeout response for each of them.
-func (s *Socket) timeout() {
+// Timed out requests are kept for the grace period, so late replies can still be matched to their request.
+// It returns the time until the next outstanding request times out, up to timeoutInterval.
+func (s *Socket) timeout() time.Duration {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	for seq, req := range s.outstandingRequests {
-		if time.Since(req.TimeSent) > s.Timeout {
-			s.logger.Debug("timeout expired", "seq", seq)
-			s.q.Push(Response{
-				ResponseType: ResponseTimeout,
-				Request:      req,
-			})
-			delete(s.outstandingRequests, seq)
+	now := time.Now()
+	for len(s.deadlines) > 0 && !s.deadlines[0].at.After(now) {
+		d := heap.Pop(&s.deadlines).(deadline)
+		if d.purge {
+			// late or duplicate replies arriving after the grace period are no longer detected
+			for _, requests := range []map[requestKey]request{s.expiredRequests, s.repliedRequests} {
+				if req, ok := requests[d.key]; ok && !s.expires(req).Add(s.gracePeriod).After(now) {
+					delete(requests, d.key)
+				}
+			}
+			continue
+		}
+		// the request may have received a reply or been canceled, and its key reused by a later request
+		req, ok := s.outstandingRequests[d.key]
+		if !ok || s.expires(req).After(now) {
+			continue
 		}
+		s.logger.Debug("timeout expired", "target", req.Target, "seq", req.Seq)
+		s.deliver(response{
+			handle: req.handle,
+			Response: Response{
+				ResponseType: ResponseTimeout,
+				Request:      req.Request,
+			},
+		})
+		delete(s.outstandingRequests, d.key)
+		s.expiredRequests[d.key] = req
+	}
+
+	next := timeoutInterval
+	if len(s.deadlines) > 0 {
+		next = min(next, s.deadlines[0].at.Sub(now))
 	}
+	s.nextCheck = now.Add(next)
+	return next
 }
 
-// setTTL sets the ttl on the socket to the provided value.
-func (s *Socket) setTTL(ttl uint8) (err error) {
-	if s.v4 != nil {
-		err = s.v4.IPv4PacketConn().SetTTL(int(ttl))
+// deliver passes the response to the Handle that sent the request and to all subscriptions.
+func (s *Socket) deliver(resp response) {
+	if resp.handle != nil {
+		resp.handle.deliver(resp.Response)
 	}
-	if s.v6 != nil {
-		err = errors.Join(err, s.v6.IPv6PacketConn().SetHopLimit(int(ttl)))
+	s.subscribers.publish(resp.Response)
+}
+
+// requestTimeout returns the timeout of a request.
+func (s *Socket) requestTimeout(req request) time.Duration {
+	return cmp.Or(req.Timeout, s.Timeout)
+}
+
+// expires returns the time a request times out.
+func (s *Socket) expires(req request) time.Time {
+	return req.TimeSent.Add(s.requestTimeout(req))
+}
+
+// requestKey returns the key of the outstanding request for the provided target, id and seq.
+func (s *Socket) requestKey(target net.IP, id int, seq SequenceNumber) requestKey {
+	addr, _ := netip.AddrFromSlice(target)
+	if !s.checkID {
+		// the kernel may have changed the id of the request: ignore it.
+		id = 0
 	}
-	return err
+	return requestKey{target: addr.Unmap(), id: id, seq: seq}
 }
 
-// parseTimeExceeded extracts Echo ID and Seq from the inner ICMP packet
-// Supports both IPv4 and IPv6 TimeExceeded messages
-func parseTimeExceeded(data []byte, src net.IP) (id int, seq SequenceNumber, err error) {
+// parseOriginalRequest extracts the destination address, Echo ID and Seq from the original datagram embedded in an ICMP error message
+// (time exceeded, destination unreachable, packet too big or parameter problem).
+// Supports both IPv4 and IPv6 messages.
+func parseOriginalRequest(data []byte, src net.IP) (dst net.IP, id int, seq SequenceNumber, err error) {
 	if src.To4() != nil {
-		return parseTimeExceededV4(data)
+		return parseOriginalRequestV4(data)
 	}
-	return parseTimeExceededV6(data)
+	return parseOriginalRequestV6(data)
 }
 
-func parseTimeExceededV4(data []byte) (id int, seq SequenceNumber, err error) {
+func parseOriginalRequestV4(data []byte) (dst net.IP, id int, seq SequenceNumber, err error) {
 	if len(data) < ipv4.HeaderLen+8 {
-		return 0, 0, errors.New("IPv4 payload too short")
+		return nil, 0, 0, errors.New("IPv4 payload too short")
 	}
 	hlen := int(data[0]&0x0f) * 4
 	if len(data) < hlen+8 {
-		return 0, 0, errors.New("IPv4 inner payload too short")
+		return nil, 0, 0, errors.New("IPv4 inner payload too short")
 	}
+	dst = net.IPv4(data[16], data[17], data[18], data[19])
 	inner := data[hlen : hlen+8]
 	id = int(binary.BigEndian.Uint16(inner[4:6]))
 	seq = SequenceNumber(binary.BigEndian.Uint16(inner[6:8]))
-	return id, seq, nil
+	return dst, id, seq, nil
 }
 
-func parseTimeExceededV6(data []byte) (id int, seq SequenceNumber, err error) {
+func parseOriginalRequestV6(data []byte) (dst net.IP, id int, seq SequenceNumber, err error) {
 	if len(data) < ipv6.HeaderLen {
-		return 0, 0, errors.New("IPv6 payload too short")
+		return nil, 0, 0, errors.New("IPv6 payload too short")
 	}
+	dst = slices.Clone(net.IP(data[24:40]))
 	inner := data[ipv6.HeaderLen:]
 	m, err := icmp.ParseMessage(58, inner)
 	if err != nil {
-		return 0, 0, err
+		return nil, 0, 0, err
 	}
 	switch b := m.Body.(type) {
 	case *icmp.Echo:
-		return b.ID, SequenceNumber(b.Seq), nil
+		return dst, b.ID, SequenceNumber(b.Seq), nil
 	default:
 		if len(inner) >= 8 {
 			id = int(binary.BigEndian.Uint16(inner[4:6]))
 			seq = SequenceNumber(binary.BigEndian.Uint16(inner[6:8]))
-			return id, seq, nil
+			return dst, id, seq, nil
 		}
-		return 0, 0, errors.New("inner ICMPv6 not Echo and too short")
+		return nil, 0, 0, errors.New("inner ICMPv6 not Echo and too short")
 	}
 }
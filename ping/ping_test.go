@@ -1,10 +1,14 @@
 package ping_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
 	"os"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -23,6 +27,8 @@ func TestSocket(t *testing.T) {
 	}{
 		{"IPv4", []ping.SocketOption{ping.WithIPv4(), ping.WithTimeout(10 * time.Second)}, "udp4", "8.8.8.8:53", "127.0.0.1"},
 		{"IPv6", []ping.SocketOption{ping.WithIPv6()}, "udp6", "[2001:4860:4860::8888]:53", "::1"},
+		{"IPv4 raw", []ping.SocketOption{ping.WithIPv4(), ping.WithMode(ping.ModeRaw)}, "udp4", "8.8.8.8:53", "127.0.0.1"},
+		{"IPv6 raw", []ping.SocketOption{ping.WithIPv6(), ping.WithMode(ping.ModeRaw)}, "udp6", "[2001:4860:4860::8888]:53", "::1"},
 	}
 
 	for _, tt := range tests {
@@ -57,6 +63,27 @@ func TestSocket(t *testing.T) {
 	}
 }
 
+func TestSocket_LargePayload(t *testing.T) {
+	for _, batchSize := range []int{1, 4} {
+		t.Run(fmt.Sprintf("batch size %d", batchSize), func(t *testing.T) {
+			socket, err := ping.New(ping.WithIPv4(), ping.WithBatchSize(batchSize), ping.WithLogger(slog.New(slog.DiscardHandler)))
+			if errors.Is(err, os.ErrPermission) {
+				t.Skip("no permission to create icmp socket")
+			}
+			require.NoError(t, err)
+			go socket.Serve(t.Context())
+
+			// the loopback interface doesn't fragment: the reply is received in one piece
+			target := net.ParseIP("127.0.0.1")
+			require.NoError(t, socket.Send(target, 1, 64, make([]byte, 4000)))
+			resp, err := socket.Read(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+			assert.False(t, resp.Corrupted)
+		})
+	}
+}
+
 func TestResponse_LogValue(t *testing.T) {
 	tests := []struct {
 		name string
@@ -90,7 +117,10 @@ func TestResponse_LogValue(t *testing.T) {
 func TestSocket_Timeout(t *testing.T) {
 	s, err := ping.New(
 		ping.WithIPv4(),
-		ping.WithTimeout(time.Second),
+		// bound to the loopback interface, packets for other addresses are sent over loopback and dropped by the
+		// kernel, as they aren't for a local address: they never receive a reply, with or without a network.
+		ping.WithInterface("lo"),
+		ping.WithTimeout(100*time.Millisecond),
 		ping.WithLogger(slog.New(slog.DiscardHandler)),
 	)
 	if errors.Is(err, os.ErrPermission) {
@@ -100,22 +130,246 @@ func TestSocket_Timeout(t *testing.T) {
 
 	go s.Serve(t.Context())
 
-	target := net.ParseIP("127.0.0.2")
+	// TEST-NET-3 (RFC 5737)
+	target := net.ParseIP("203.0.113.1")
 	require.NoError(t, s.Send(target, 10, 64, []byte("payload")))
 
 	// wait for the timeout response
-	var resp ping.Response
-	require.Eventually(t, func() bool {
-		resp, err = s.Read(t.Context())
-		return err == nil
-	}, 5*time.Second, 500*time.Millisecond)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	resp, err := s.Read(ctx)
+	require.NoError(t, err)
 
 	want := ping.Response{
 		ResponseType: ping.ResponseTimeout,
-		Request:      ping.Request{Target: target, Seq: 10, TTL: 64},
+		Request:      ping.Request{Target: target, Seq: 10, TTL: 64, Timeout: 100 * time.Millisecond},
 	}
 	// clear TimeSent so we can compare but check it's set in the response
 	assert.NotZero(t, resp.Request.TimeSent)
 	resp.Request.TimeSent = time.Time{}
 	assert.Equal(t, want, resp)
 }
+
+func TestResponse_Reason(t *testing.T) {
+	tests := []struct {
+		name string
+		resp ping.Response
+		want string
+	}{
+		{"ipv4 port unreachable", ping.Response{ResponseType: ping.ResponseDestinationUnreachable, Code: 3, From: net.ParseIP("10.0.0.1")}, "port unreachable"},
+		{"ipv4 admin prohibited", ping.Response{ResponseType: ping.ResponseDestinationUnreachable, Code: 13, From: net.ParseIP("10.0.0.1")}, "communication administratively prohibited"},
+		{"ipv4 fragmentation needed", ping.Response{ResponseType: ping.ResponsePacketTooBig, Code: 4, From: net.ParseIP("10.0.0.1")}, "fragmentation needed"},
+		{"ipv6 port unreachable", ping.Response{ResponseType: ping.ResponseDestinationUnreachable, Code: 4, From: net.ParseIP("fd00::1")}, "port unreachable"},
+		{"ipv6 packet too big", ping.Response{ResponseType: ping.ResponsePacketTooBig, From: net.ParseIP("fd00::1")}, "packet too big"},
+		{"unknown code", ping.Response{ResponseType: ping.ResponseDestinationUnreachable, Code: 99, From: net.ParseIP("10.0.0.1")}, "code 99"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.resp.Reason())
+			assert.True(t, tt.resp.ResponseType.IsError())
+		})
+	}
+}
+
+func TestSocket_Lockstep(t *testing.T) {
+	// WithoutCheckID: in datagram mode, the kernel may change the ID of our requests
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+
+	// ping all targets with the same sequence numbers
+	const targetCount = 50
+	const packetCount = 5
+	for seq := range ping.SequenceNumber(packetCount) {
+		for i := range targetCount {
+			require.NoError(t, s.Send(net.IPv4(127, 0, 0, byte(i+1)), seq, 64, []byte("payload")))
+		}
+	}
+
+	received := make(map[string]map[ping.SequenceNumber]struct{})
+	for range targetCount * packetCount {
+		resp, err := s.Read(ctx)
+		require.NoError(t, err)
+		require.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+		// every reply must be matched to the request sent to the replying target
+		require.True(t, resp.From.Equal(resp.Request.Target), "from: %s, target: %s", resp.From, resp.Request.Target)
+		if received[resp.From.String()] == nil {
+			received[resp.From.String()] = make(map[ping.SequenceNumber]struct{})
+		}
+		received[resp.From.String()][resp.Request.Seq] = struct{}{}
+	}
+	assert.Len(t, received, targetCount)
+	for target, seqs := range received {
+		assert.Len(t, seqs, packetCount, target)
+	}
+}
+
+func TestSocket_ConcurrentTTL(t *testing.T) {
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+
+	// send packets with different TTLs concurrently: each packet is sent with its own TTL
+	const senders = 10
+	const packetCount = 10
+	var wg sync.WaitGroup
+	for i := range senders {
+		wg.Go(func() {
+			for seq := range ping.SequenceNumber(packetCount) {
+				assert.NoError(t, s.Send(net.IPv4(127, 0, 0, byte(i+1)), seq, uint8(i+1), []byte("payload")))
+			}
+		})
+	}
+	wg.Wait()
+
+	for range senders * packetCount {
+		resp, err := s.Read(ctx)
+		require.NoError(t, err)
+		require.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+		assert.Equal(t, resp.Request.Target.To4()[3], resp.Request.TTL)
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	for _, mode := range []ping.SocketMode{ping.ModeDatagram, ping.ModeRaw, ping.ModeAuto} {
+		got, err := ping.ParseSocketMode(mode.String())
+		require.NoError(t, err)
+		assert.Equal(t, mode, got)
+	}
+	_, err := ping.ParseSocketMode("foo")
+	assert.Error(t, err)
+}
+
+func TestSocket_KernelTimestamps(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("kernel timestamps are only supported on linux")
+	}
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithKernelTimestamps(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+
+	// the kernel enables timestamping asynchronously, so the first packets may not have a kernel timestamp
+	var sources []ping.TimestampSource
+	for seq := range ping.SequenceNumber(10) {
+		require.NoError(t, s.Send(net.ParseIP("127.0.0.1"), seq, 64, []byte("payload")))
+		resp, err := s.Read(ctx)
+		require.NoError(t, err)
+		require.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+		// the received TTL is reported alongside the timestamp
+		assert.NotZero(t, resp.TTL)
+		if sources = append(sources, resp.TimestampSource); resp.TimestampSource != ping.TimestampUserspace {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotEqual(t, ping.TimestampUserspace, sources[len(sources)-1], sources)
+}
+
+func TestTimestampSource_String(t *testing.T) {
+	assert.Equal(t, "userspace", ping.TimestampUserspace.String())
+	assert.Equal(t, "kernel", ping.TimestampKernel.String())
+	assert.Equal(t, "unknown", ping.TimestampSource(-1).String())
+}
+
+func TestSocket_SourceAddress(t *testing.T) {
+	s, err := ping.New(ping.WithIPv4(), ping.WithIPv6(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithSourceAddress(net.ParseIP("127.0.0.1")), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	// only the IPv4 socket is opened
+	_, err = s.Resolve("::1")
+	assert.Error(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+	require.NoError(t, s.Send(net.ParseIP("127.0.0.1"), 1, 64, []byte("payload")))
+	resp, err := s.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+
+	// source address must match an enabled IP version
+	_, err = ping.New(ping.WithIPv4(), ping.WithSourceAddress(net.ParseIP("::1")), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	assert.Error(t, err)
+}
+
+func TestSocket_Interface(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to an interface is only supported on linux")
+	}
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithInterface("lo"), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+	require.NoError(t, s.Send(net.ParseIP("127.0.0.1"), 1, 64, []byte("payload")))
+	resp, err := s.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+
+	_, err = ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithInterface("invalid0"), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	assert.Error(t, err)
+}
+
+func TestSocket_Mark(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("setting a mark is only supported on linux")
+	}
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithMark(42), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("setting a mark requires CAP_NET_ADMIN")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+	require.NoError(t, s.Send(net.ParseIP("127.0.0.1"), 1, 64, []byte("payload")))
+	resp, err := s.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ping.ResponseEchoReply, resp.ResponseType)
+}
+
+func TestParseDSCP(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    uint8
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"EF", 46, assert.NoError},
+		{"af41", 34, assert.NoError},
+		{"CS1", 8, assert.NoError},
+		{"0", 0, assert.NoError},
+		{"46", 46, assert.NoError},
+		{"010", 10, assert.NoError},
+		{"0x2e", 0, assert.Error},
+		{"64", 0, assert.Error},
+		{"foo", 0, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ping.ParseDSCP(tt.name)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
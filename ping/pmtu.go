@@ -0,0 +1,178 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// MinMTUv4 is the minimum MTU that every IPv4 link must support (RFC 791).
+	MinMTUv4 = 68
+	// MinMTUv6 is the minimum MTU that every IPv6 link must support (RFC 8200).
+	MinMTUv6 = 1280
+	// icmpHeaderLen is the size of the header of an icmp echo request.
+	icmpHeaderLen = 8
+	// pmtuRetries is the number of lost probes DiscoverPMTU accepts before it considers a packet size too big.
+	pmtuRetries = 1
+)
+
+// DataLen returns the size of the data to pass to Send, so the resulting IP packet to the target has the provided size.
+func DataLen(target net.IP, packetSize int) int {
+	overhead := ipv6.HeaderLen
+	if target.To4() != nil {
+		overhead = ipv4.HeaderLen
+	}
+	return max(0, packetSize-overhead-icmpHeaderLen-payloadHeaderLen)
+}
+
+// MTUSearch performs a binary search for the path MTU to a target, i.e. the size of the largest IP packet that reaches
+// the target without being fragmented. The caller sends a probe of the size returned by Next (with the don't fragment
+// flag set) and reports the outcome with Fits, TooBig or Lost, until Next reports that the search is done.
+//
+// The first probe uses the maximum size, as that's the most likely outcome. If a router reports the next-hop MTU, the
+// next probe uses that size.
+type MTUSearch struct {
+	minMTU  int
+	low     int  // largest size known to reach the target
+	high    int  // largest size that may reach the target
+	tryHigh bool // probe high, rather than halfway between low and high
+	lost    int  // consecutive lost probes
+	retries int
+}
+
+// NewMTUSearch returns an MTUSearch for a path MTU between minMTU and maxMTU. Retries is the number of lost probes
+// that are retried before a size is considered too big: some routers drop packets that are too big without reporting it.
+func NewMTUSearch(minMTU, maxMTU, retries int) *MTUSearch {
+	return &MTUSearch{
+		minMTU:  minMTU,
+		low:     minMTU - 1,
+		high:    max(minMTU, maxMTU),
+		tryHigh: true,
+		retries: retries,
+	}
+}
+
+// Next returns the size of the next probe. If done is true, the search is complete and MTU returns the result.
+func (m *MTUSearch) Next() (size int, done bool) {
+	if m.low >= m.high {
+		return 0, true
+	}
+	if m.tryHigh {
+		return m.high, false
+	}
+	return m.low + (m.high-m.low+1)/2, false
+}
+
+// Fits records that a probe of the provided size reached the target.
+func (m *MTUSearch) Fits(size int) {
+	m.lost = 0
+	m.low = max(m.low, min(size, m.high))
+}
+
+// TooBig records that a probe of the provided size was too big. If the router reported the next-hop MTU, mtu holds its
+// value. Otherwise, mtu is zero.
+func (m *MTUSearch) TooBig(size, mtu int) {
+	m.lost = 0
+	m.tryHigh = false
+	high := size - 1
+	if mtu > m.low && mtu < size {
+		high = mtu
+		m.tryHigh = true
+	}
+	m.high = max(m.low, min(m.high, high))
+}
+
+// Lost records that a probe of the provided size didn't receive a response.
+func (m *MTUSearch) Lost(size int) {
+	if m.lost++; m.lost > m.retries {
+		m.TooBig(size, 0)
+	}
+}
+
+// MTU returns the largest packet size that reached the target. It returns zero if no probe reached the target.
+func (m *MTUSearch) MTU() int {
+	if m.low < m.minMTU {
+		return 0
+	}
+	return m.low
+}
+
+// DiscoverPMTU determines the path MTU to the target, up to maxMTU bytes. The Socket must be created with
+// WithDontFragment and must receive icmp errors, so it sees "packet too big" messages (see ReceivesErrors).
+//
+// Like Trace, DiscoverPMTU uses its own Handle, so it can be used while other consumers use the same Socket.
+// Serve must be running.
+func (s *Socket) DiscoverPMTU(ctx context.Context, target net.IP, maxMTU int) (int, error) {
+	if !s.dontFragment {
+		return 0, errors.New("path MTU discovery requires WithDontFragment")
+	}
+	if !s.ReceivesErrors(target) {
+		return 0, fmt.Errorf("path MTU discovery for %s: %w", target, ErrNoICMPErrors)
+	}
+	minMTU := MinMTUv6
+	if target.To4() != nil {
+		minMTU = MinMTUv4
+	}
+	if maxMTU < minMTU {
+		return 0, fmt.Errorf("invalid maxMTU: %d", maxMTU)
+	}
+	search := NewMTUSearch(minMTU, maxMTU, pmtuRetries)
+	h := s.Register(defaultSubscriptionSize)
+	defer h.Close()
+	var seq SequenceNumber
+	for {
+		size, done := search.Next()
+		if done {
+			break
+		}
+		if err := s.pmtuProbe(ctx, h, target, size, seq, search); err != nil {
+			return 0, err
+		}
+		seq++
+	}
+	if search.MTU() == 0 {
+		return 0, fmt.Errorf("no response from %s", target)
+	}
+	return search.MTU(), nil
+}
+
+// pmtuProbe sends one probe of the provided size and records the outcome in the search.
+func (s *Socket) pmtuProbe(ctx context.Context, h *Handle, target net.IP, size int, seq SequenceNumber, search *MTUSearch) error {
+	if err := h.Send(target, seq, 0, make([]byte, DataLen(target, size))); err != nil {
+		if errors.Is(err, ErrPacketTooBig) {
+			// larger than the MTU of the outgoing interface
+			search.TooBig(size, 0)
+			return nil
+		}
+		return fmt.Errorf("send: %w", err)
+	}
+	for {
+		// timed-out requests are reported by the Socket as a ResponseTimeout
+		resp, err := h.Read(ctx)
+		if err != nil {
+			return err
+		}
+		if resp.Request.Seq != seq {
+			// a late reply to a previous probe
+			continue
+		}
+		switch resp.ResponseType {
+		case ResponseEchoReply:
+			search.Fits(size)
+		case ResponsePacketTooBig:
+			search.TooBig(size, resp.MTU)
+		case ResponseTimeout:
+			search.Lost(size)
+		case ResponseDuplicate, ResponseLate:
+			continue
+		default:
+			return fmt.Errorf("%s: %s", resp.ResponseType, resp.Reason())
+		}
+		return nil
+	}
+}
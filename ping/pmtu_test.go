@@ -0,0 +1,87 @@
+package ping_test
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocket_DiscoverPMTU(t *testing.T) {
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithDontFragment(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+
+	// loopback's MTU is larger than the maximum we probe for
+	target := net.ParseIP("127.0.0.1")
+	mtu, err := s.DiscoverPMTU(ctx, target, 1500)
+	require.NoError(t, err)
+	assert.Equal(t, 1500, mtu)
+
+	_, err = s.DiscoverPMTU(ctx, target, 10)
+	assert.Error(t, err)
+}
+
+func TestSocket_DiscoverPMTU_DontFragment(t *testing.T) {
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+	_, err = s.DiscoverPMTU(t.Context(), net.ParseIP("127.0.0.1"), 1500)
+	assert.Error(t, err)
+}
+
+func TestMTUSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pathMTU int
+		report  bool
+		drop    bool
+		want    int
+		maxRuns int
+	}{
+		{name: "fits", pathMTU: 9000, want: 1500, maxRuns: 1},
+		{name: "router reports mtu", pathMTU: 1400, report: true, want: 1400, maxRuns: 2},
+		{name: "router doesn't report mtu", pathMTU: 1400, want: 1400, maxRuns: 12},
+		{name: "black hole", pathMTU: 1400, drop: true, want: 1400, maxRuns: 20},
+		{name: "unreachable", pathMTU: 0, drop: true, want: 0, maxRuns: 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := ping.NewMTUSearch(ping.MinMTUv4, 1500, 1)
+			var runs int
+			for size, done := m.Next(); !done; size, done = m.Next() {
+				runs++
+				switch {
+				case size <= tt.pathMTU:
+					m.Fits(size)
+				case tt.drop:
+					m.Lost(size)
+				case tt.report:
+					m.TooBig(size, tt.pathMTU)
+				default:
+					m.TooBig(size, 0)
+				}
+			}
+			assert.Equal(t, tt.want, m.MTU())
+			assert.LessOrEqual(t, runs, tt.maxRuns)
+		})
+	}
+}
+
+func TestDataLen(t *testing.T) {
+	assert.Equal(t, 1500-20-8-20, ping.DataLen(net.ParseIP("127.0.0.1"), 1500))
+	assert.Equal(t, 1500-40-8-20, ping.DataLen(net.ParseIP("::1"), 1500))
+	assert.Zero(t, ping.DataLen(net.ParseIP("127.0.0.1"), 10))
+}
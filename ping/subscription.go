@@ -0,0 +1,128 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultSubscriptionSize is the buffer size of the Handle used by Socket.Send and Socket.Read.
+const defaultSubscriptionSize = 1024
+
+// ErrSubscriptionClosed is returned by Read after the Subscription or Handle is closed.
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// A Subscription receives all responses of a Socket, including timeouts and responses to requests sent by other Handles.
+// Responses are buffered: if the buffer is full, new responses are dropped and counted (see Dropped), so a slow consumer
+// never blocks the Socket or other consumers.
+type Subscription struct {
+	*mailbox
+	socket *Socket
+}
+
+// Subscribe creates a new Subscription, buffering up to size responses. Call Close when the subscription is no longer needed.
+func (s *Socket) Subscribe(size int) *Subscription {
+	sub := Subscription{
+		mailbox: newMailbox(size),
+		socket:  s,
+	}
+	s.subscribers.add(&sub)
+	return &sub
+}
+
+// Close stops the subscription and closes its channel.
+func (sub *Subscription) Close() {
+	sub.socket.subscribers.remove(sub)
+}
+
+// mailbox is a bounded buffer of responses, shared by Subscription and Handle.
+type mailbox struct {
+	ch      chan Response
+	dropped atomic.Uint64
+	lock    sync.Mutex
+	closed  bool
+}
+
+func newMailbox(size int) *mailbox {
+	return &mailbox{ch: make(chan Response, max(1, size))}
+}
+
+// Responses returns the channel that receives the responses. The channel is closed when the Subscription or Handle is closed.
+func (m *mailbox) Responses() <-chan Response {
+	return m.ch
+}
+
+// Read returns the next response. It blocks until a response is received, the Subscription or Handle is closed, or the context
+// is canceled.
+func (m *mailbox) Read(ctx context.Context) (Response, error) {
+	select {
+	case <-ctx.Done():
+		return Response{}, ctx.Err()
+	case r, ok := <-m.ch:
+		if !ok {
+			return Response{}, ErrSubscriptionClosed
+		}
+		return r, nil
+	}
+}
+
+// Dropped returns the number of responses that were dropped because the buffer was full.
+func (m *mailbox) Dropped() uint64 {
+	return m.dropped.Load()
+}
+
+// deliver adds the response to the buffer. It never blocks: if the buffer is full, the response is dropped.
+func (m *mailbox) deliver(r Response) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.ch <- r:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+// close closes the channel. Later responses are discarded.
+func (m *mailbox) close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.ch)
+	}
+}
+
+// subscribers holds the subscriptions of a Socket.
+type subscribers struct {
+	subscriptions map[*Subscription]struct{}
+	lock          sync.Mutex
+}
+
+func (s *subscribers) add(sub *Subscription) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[*Subscription]struct{})
+	}
+	s.subscriptions[sub] = struct{}{}
+}
+
+func (s *subscribers) remove(sub *Subscription) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscriptions, sub)
+	sub.close()
+}
+
+// publish delivers a response to all subscriptions. It never blocks.
+func (s *subscribers) publish(r Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for sub := range s.subscriptions {
+		sub.deliver(r)
+	}
+}
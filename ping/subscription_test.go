@@ -0,0 +1,80 @@
+package ping
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscription(t *testing.T) {
+	s, err := New(WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+
+	sub1 := s.Subscribe(2)
+	sub2 := s.Subscribe(1)
+
+	s.subscribers.publish(Response{Request: Request{Seq: 1}})
+	s.subscribers.publish(Response{Request: Request{Seq: 2}})
+
+	// each subscription receives all responses
+	for _, seq := range []SequenceNumber{1, 2} {
+		resp, err := sub1.Read(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, seq, resp.Request.Seq)
+	}
+	assert.Zero(t, sub1.Dropped())
+
+	// a full subscription drops new responses
+	resp, err := sub2.Read(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, SequenceNumber(1), resp.Request.Seq)
+	assert.Equal(t, uint64(1), sub2.Dropped())
+
+	// a closed subscription no longer receives responses
+	sub2.Close()
+	sub2.Close()
+	s.subscribers.publish(Response{Request: Request{Seq: 3}})
+	_, err = sub2.Read(t.Context())
+	assert.ErrorIs(t, err, ErrSubscriptionClosed)
+	_, ok := <-sub2.Responses()
+	assert.False(t, ok)
+
+	resp, err = sub1.Read(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, SequenceNumber(3), resp.Request.Seq)
+
+	// responses without a Handle (e.g. unmatched replies) are only published to subscriptions
+	s.deliver(response{Response: Response{ResponseType: ResponseUnmatched, Request: Request{Seq: 4}}})
+	resp, err = sub1.Read(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, ResponseUnmatched, resp.ResponseType)
+	assert.Empty(t, s.handle.Responses())
+}
+
+func TestSubscription_Read(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s, err := New(WithLogger(slog.New(slog.DiscardHandler)))
+		require.NoError(t, err)
+		sub := s.Subscribe(1)
+		defer sub.Close()
+
+		// an idle subscription blocks until the context is canceled
+		ctx, cancel := context.WithTimeout(t.Context(), time.Hour)
+		defer cancel()
+		_, err = sub.Read(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// a subscription receives responses for all Handles
+		h := s.Register(1)
+		defer h.Close()
+		go s.deliver(response{handle: h, Response: Response{Request: Request{Seq: 1}}})
+		resp, err := sub.Read(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, SequenceNumber(1), resp.Request.Seq)
+	})
+}
@@ -0,0 +1,106 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Hop represents one hop on the path to a target, as discovered by Trace.
+type Hop struct {
+	// Addr is the address of the node that responded. Addr is nil if none of the probes for this hop received a response.
+	Addr net.IP
+	// Latencies holds the latency of each probe that received a response.
+	Latencies []time.Duration
+	// TTL is the TTL of the probes sent for this hop.
+	TTL uint8
+	// Sent is the number of probes sent for this hop.
+	Sent int
+	// Received is the number of probes that received a response.
+	Received int
+}
+
+// Loss returns the fraction of probes for this hop that did not receive a response.
+func (h Hop) Loss() float64 {
+	if h.Sent == 0 {
+		return 0
+	}
+	return float64(h.Sent-h.Received) / float64(h.Sent)
+}
+
+// Trace determines the path to target by sending probesPerHop echo requests with an increasing TTL, starting at 1.
+// It returns the hops in order of their distance to the Socket. Trace stops when the target replies, when a hop returns
+// an icmp error (e.g., destination unreachable), or when maxHops is reached.
+//
+// Trace uses its own Handle, so it can be used while other consumers use the same Socket. Serve must be running.
+// Trace needs the time exceeded messages sent by each hop: it returns ErrNoICMPErrors if the Socket doesn't
+// receive icmp errors (see ReceivesErrors).
+func (s *Socket) Trace(ctx context.Context, target net.IP, maxHops, probesPerHop int) ([]Hop, error) {
+	if !s.ReceivesErrors(target) {
+		return nil, fmt.Errorf("trace %s: %w", target, ErrNoICMPErrors)
+	}
+	if maxHops < 1 || maxHops > 255 {
+		return nil, fmt.Errorf("invalid maxHops: %d", maxHops)
+	}
+	if probesPerHop < 1 {
+		return nil, fmt.Errorf("invalid probesPerHop: %d", probesPerHop)
+	}
+	h := s.Register(defaultSubscriptionSize)
+	defer h.Close()
+	hops := make([]Hop, 0, maxHops)
+	var seq SequenceNumber
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		hop, done, err := s.traceHop(ctx, h, target, uint8(ttl), probesPerHop, &seq)
+		if err != nil {
+			return hops, err
+		}
+		hops = append(hops, hop)
+		if done {
+			break
+		}
+	}
+	return hops, nil
+}
+
+// traceHop sends probesPerHop echo requests to the target with the provided ttl and waits for all of them to receive
+// a response or to time out. It returns true if the target itself replied.
+func (s *Socket) traceHop(ctx context.Context, h *Handle, target net.IP, ttl uint8, probesPerHop int, seq *SequenceNumber) (Hop, bool, error) {
+	hop := Hop{TTL: ttl}
+	pending := make(map[SequenceNumber]struct{}, probesPerHop)
+	for range probesPerHop {
+		if err := h.Send(target, *seq, ttl, []byte("traceroute")); err != nil {
+			return hop, false, fmt.Errorf("send: %w", err)
+		}
+		pending[*seq] = struct{}{}
+		hop.Sent++
+		*seq++
+	}
+
+	var done bool
+	for len(pending) > 0 {
+		// timed-out requests are reported by the Socket as a ResponseTimeout
+		resp, err := h.Read(ctx)
+		if err != nil {
+			return hop, false, err
+		}
+		if _, ok := pending[resp.Request.Seq]; !ok {
+			// e.g. a late reply to a probe for a previous hop
+			continue
+		}
+		delete(pending, resp.Request.Seq)
+
+		if resp.ResponseType == ResponseTimeout {
+			// the probe is lost
+			continue
+		}
+		if hop.Addr == nil {
+			hop.Addr = resp.From
+		}
+		hop.Received++
+		hop.Latencies = append(hop.Latencies, resp.Latency)
+		// an echo reply means we reached the target. an error means we can't go any further.
+		done = done || resp.ResponseType == ResponseEchoReply || resp.ResponseType.IsError()
+	}
+	return hop, done, nil
+}
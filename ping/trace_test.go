@@ -0,0 +1,70 @@
+package ping_test
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/clambin/pinger/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocket_Trace(t *testing.T) {
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeAuto), ping.WithoutCheckID(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	go s.Serve(ctx)
+
+	target := net.ParseIP("127.0.0.1")
+	if !s.ReceivesErrors(target) {
+		t.Skip("raw sockets not supported")
+	}
+	hops, err := s.Trace(ctx, target, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, hops, 1)
+	assert.Equal(t, target.String(), hops[0].Addr.String())
+	assert.Equal(t, uint8(1), hops[0].TTL)
+	assert.Equal(t, 3, hops[0].Sent)
+	assert.Equal(t, 3, hops[0].Received)
+	assert.Len(t, hops[0].Latencies, 3)
+	assert.Zero(t, hops[0].Loss())
+
+	_, err = s.Trace(ctx, target, 0, 3)
+	assert.Error(t, err)
+	_, err = s.Trace(ctx, target, 5, 0)
+	assert.Error(t, err)
+}
+
+func TestSocket_Trace_Datagram(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only linux withholds icmp errors from datagram sockets")
+	}
+	s, err := ping.New(ping.WithIPv4(), ping.WithMode(ping.ModeDatagram), ping.WithDontFragment(), ping.WithLogger(slog.New(slog.DiscardHandler)))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("IPv4 not supported")
+	}
+	require.NoError(t, err)
+
+	target := net.ParseIP("127.0.0.1")
+	assert.False(t, s.ReceivesErrors(target))
+	_, err = s.Trace(t.Context(), target, 5, 3)
+	assert.ErrorIs(t, err, ping.ErrNoICMPErrors)
+	_, err = s.DiscoverPMTU(t.Context(), target, 1500)
+	assert.ErrorIs(t, err, ping.ErrNoICMPErrors)
+	// IPv6 is not enabled
+	assert.False(t, s.ReceivesErrors(net.ParseIP("::1")))
+}
+
+func TestHop_Loss(t *testing.T) {
+	assert.Zero(t, ping.Hop{}.Loss())
+	assert.Equal(t, 0.25, ping.Hop{Sent: 4, Received: 3}.Loss())
+	assert.Equal(t, 1.0, ping.Hop{Sent: 3}.Loss())
+}